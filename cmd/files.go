@@ -0,0 +1,189 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
+)
+
+// Flags for processing several files, shared by the huff and unhuff commands
+var (
+	recursiveFlag bool
+	includeFlag   []string
+	excludeFlag   []string
+)
+
+// collectFiles returns the files named by args. Directories are only allowed if
+// recursive is set, in which case every regular file under them whose name matches
+// one of the include patterns, if any, and none of the exclude patterns is returned.
+// Files found in directories are also skipped if want is not nil and returns false.
+func collectFiles(args []string, recursive bool, include, exclude []string, want func(name string) bool) ([]string, error) {
+	for _, patterns := range [][]string{include, exclude} {
+		for _, pattern := range patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	matches := func(name string) bool {
+		if want != nil && !want(name) {
+			return false
+		}
+		for _, pattern := range exclude {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return false
+			}
+		}
+		for _, pattern := range include {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return len(include) == 0
+	}
+
+	var fileNames []string
+	for _, arg := range args {
+		if arg == "-" {
+			return nil, errors.New("- cannot be used with other files or -r")
+		}
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			fileNames = append(fileNames, arg)
+			continue
+		}
+		if !recursive {
+			return nil, fmt.Errorf("%s is a directory (use -r)", arg)
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.Type().IsRegular() && matches(d.Name()) {
+				fileNames = append(fileNames, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fileNames, nil
+}
+
+// isHuffFile reports whether name has a .huff extension
+func isHuffFile(name string) bool {
+	return filepath.Ext(name) == ".huff"
+}
+
+// notHuffFile reports whether name does not have a .huff extension
+func notHuffFile(name string) bool {
+	return !isHuffFile(name)
+}
+
+// isDir reports whether name is a directory
+func isDir(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+// processFiles calls process on each file, which returns the name of the file it
+// wrote, and reports the sizes of each on w followed by the totals. Files that fail
+// are reported and skipped. Returns an error wrapping the first failure if any file
+// failed.
+func processFiles(w io.Writer, fileNames []string, process func(fileName string) (string, error)) error {
+	var firstErr error
+	var inputTotal, outputTotal int64
+	failed := 0
+	for _, fileName := range fileNames {
+		//	The input is sized first, since it may be removed once processed
+		inputInfo, err := os.Stat(fileName)
+		var outputFileName string
+		if err == nil {
+			outputFileName, err = process(fileName)
+		}
+		var outputInfo fs.FileInfo
+		if err == nil {
+			outputInfo, err = os.Stat(outputFileName)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%s: FAILED (%v)\n", fileName, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+
+		fmt.Fprintf(w, "%s -> %s: %s\n", fileName, outputFileName, sizes(inputInfo.Size(), outputInfo.Size()))
+		inputTotal += inputInfo.Size()
+		outputTotal += outputInfo.Size()
+	}
+
+	fmt.Fprintf(w, "Total: %d files, %s", len(fileNames)-failed, sizes(inputTotal, outputTotal))
+	if failed > 0 {
+		fmt.Fprintf(w, ", %d failed", failed)
+	}
+	fmt.Fprintln(w)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed: %w", failed, len(fileNames), firstErr)
+	}
+	return nil
+}
+
+// sizes describes an input and output size and the ratio between them
+func sizes(input, output int64) string {
+	s := fmt.Sprintf("%d -> %d bytes", input, output)
+	if input > 0 {
+		s += fmt.Sprintf(" (%.2f%%)", float64(output)/float64(input)*100)
+	}
+	return s
+}
+
+// compressFiles compresses each file to a .huff file next to it. Nothing is
+// compressed if two of the files would be compressed to the same .huff file, such as
+// a.txt and a.log.
+func compressFiles(w io.Writer, fileNames []string, e *huffmyfile.Encoder) error {
+	inputs := make(map[string]string)
+	for _, fileName := range fileNames {
+		outputFileName := filepath.Clean(huffmyfile.EncodedFileName(fileName))
+		if other, ok := inputs[outputFileName]; ok {
+			return fmt.Errorf("%s and %s would both be compressed to %s", other, fileName, outputFileName)
+		}
+		inputs[outputFileName] = fileName
+	}
+
+	e.Quiet = true
+	return processFiles(w, fileNames, func(fileName string) (string, error) {
+		outputFileName := huffmyfile.EncodedFileName(fileName)
+		return outputFileName, huffmyfile.Encode(fileName, outputFileName, e)
+	})
+}
+
+// decompressFiles decompresses each .huff file to its original name next to it
+func decompressFiles(w io.Writer, fileNames []string, e *huffmyfile.Encoder) error {
+	e.Quiet = true
+	return processFiles(w, fileNames, func(fileName string) (string, error) {
+		outputFileName, err := e.DecodedFileName(fileName)
+		if err != nil {
+			return "", err
+		}
+		return outputFileName, huffmyfile.Decode(fileName, outputFileName, e)
+	})
+}
@@ -9,6 +9,14 @@ https://opensource.org/licenses/MIT.
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
 	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
 
 	"github.com/spf13/cobra"
@@ -17,21 +25,162 @@ import (
 // huffCmd represents the huff command
 var huffCmd = &cobra.Command{
 	Use:   "huff",
-	Short: "Compresses .txt files into .huff files. Usage: `huffmyfile huff [FILE]`",
-	Run: func(cmd *cobra.Command, args []string) {
+	Short: "Compresses .txt files into .huff files. Usage: `huffmyfile huff [FILE]...`",
+	Long: `Compresses .txt files into .huff files. Usage: ` + "`huffmyfile huff [FILE]...`" + `
+
+With -c, the output is written to standard output instead of a .huff file. If FILE
+is -, standard input is compressed to standard output in a single pass. Use -o to
+choose the output file; existing files are only replaced with -f.
+
+With several files, or with -r to compress every file in the given directories and
+their subdirectories, each file is compressed next to the original, followed by a
+summary of the sizes of each and the totals. Files that already have a .huff
+extension are skipped in directories.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		e := huffmyfile.Encoder{}
-		e.EncodeToDefaultOutputFile(args[0])
+		alphabet, err := huffmyfile.ParseAlphabet(alphabetFlag)
+		if err != nil {
+			return err
+		}
+		e.Alphabet = alphabet
+		if e.BlockSize, err = parseSize(blockSizeFlag); err != nil {
+			return err
+		}
+		e.Threads = threadsFlag
+		if e.Dictionary, err = loadDictionary(dictFlag); err != nil {
+			return err
+		}
+		e.BlockChecksums = blockChecksumsFlag
+		e.MaxCodeLength = maxCodeLengthFlag
+		if e.Coding, err = huffmyfile.ParseCoding(modeFlag); err != nil {
+			return err
+		}
+		if e.Method, err = huffmyfile.ParseMethod(methodFlag); err != nil {
+			return err
+		}
+		e.Level = levelFlag
+		if windowFlag != "" {
+			if e.Window, err = parseSize(windowFlag); err != nil {
+				return err
+			}
+		}
+		e.Overwrite = forceFlag
+		e.NoName = noNameFlag
+		e.RemoveInput = rmFlag
+
+		if len(args) > 1 || recursiveFlag || isDir(args[0]) {
+			if stdoutFlag || outputFlag != "" {
+				return errors.New("-c and -o can only be used with a single file")
+			}
+			fileNames, err := collectFiles(args, recursiveFlag, includeFlag, excludeFlag, notHuffFile)
+			if err != nil {
+				return err
+			}
+			return compressFiles(os.Stdout, fileNames, &e)
+		}
+
+		switch {
+		case (args[0] == "-" || stdoutFlag) && rmFlag:
+			return errors.New("--rm cannot be used when reading standard input or writing standard output")
+		case args[0] == "-" || stdoutFlag:
+			return streamFiles(args[0], outputFlag, func(w io.Writer, r io.Reader) error {
+				var inputInfo fs.FileInfo
+				if args[0] != "-" {
+					if inputInfo, err = os.Stat(args[0]); err != nil {
+						return err
+					}
+				}
+				return e.EncodeStream(w, r, inputInfo)
+			})
+		case outputFlag != "":
+			return huffmyfile.Encode(args[0], outputFlag, &e)
+		}
+		return e.EncodeToDefaultOutputFile(args[0])
 	},
 }
 
+// streamFiles opens the input and output files and passes them to stream. An input
+// of - is standard input, and an output of - or "" is standard output. An existing
+// output file is only replaced if --force was given, and only once stream succeeds.
+func streamFiles(inputFileName, outputFileName string, stream func(w io.Writer, r io.Reader) error) (err error) {
+	r := os.Stdin
+	if inputFileName != "-" {
+		if r, err = os.Open(inputFileName); err != nil {
+			return err
+		}
+		defer r.Close()
+	}
+	if outputFileName == "" || outputFileName == "-" {
+		return stream(os.Stdout, r)
+	}
+
+	w, err := huffmyfile.CreateOutputFile(outputFileName, forceFlag)
+	if err != nil {
+		return err
+	}
+	defer w.Abort()
+	if err := stream(w, r); err != nil {
+		return err
+	}
+	return w.Commit()
+}
+
+// parseSize parses a size such as 4096, 64K, 64KiB or 1MiB. K, M and G are all
+// taken as powers of 1024.
+func parseSize(s string) (int, error) {
+	units := []struct {
+		suffix string
+		scale  int
+	}{
+		{"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+		{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	scale := 1
+	number := s
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			number, scale = s[:len(s)-len(u.suffix)], u.scale
+			break
+		}
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(number))
+	if err != nil || n <= 0 || n > huffmyfile.MaxBlockSize/scale {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * scale, nil
+}
+
+// Flags for the huff command
+var (
+	alphabetFlag  string
+	blockSizeFlag string
+	threadsFlag   int    // Shared with the unhuff and test commands
+	stdoutFlag    bool   // Shared with the unhuff command
+	outputFlag    string // Shared with the unhuff command
+	forceFlag     bool   // Shared with the unhuff command
+	noNameFlag    bool   // Shared with the unhuff command
+	keepFlag      bool   // Shared with the unhuff command
+	rmFlag        bool   // Shared with the unhuff command
+
+	blockChecksumsFlag bool
+	maxCodeLengthFlag  int
+	modeFlag           string
+	methodFlag         string
+	levelFlag          int
+	windowFlag         string
+)
+
 // Function to return huff command for testing
 func NewHuffCmd(testFileName string) *cobra.Command {
 	return &cobra.Command{
 		Use:   "huff",
 		Short: "Compresses .txt files into .huff files. Usage: `huffmyfile huff [FILE]`",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			e := huffmyfile.Encoder{}
-			e.EncodeToDefaultOutputFile(testFileName)
+			return e.EncodeToDefaultOutputFile(testFileName)
 		},
 	}
 }
@@ -39,6 +188,40 @@ func NewHuffCmd(testFileName string) *cobra.Command {
 func init() {
 	rootCmd.AddCommand(huffCmd)
 
+	huffCmd.Flags().BoolVarP(&stdoutFlag, "stdout", "c", false, "write to standard output instead of a file")
+	huffCmd.Flags().StringVarP(&outputFlag, "output", "o", "", "write to the given file instead of FILE with a .huff extension")
+	huffCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace the output file if it already exists")
+	huffCmd.MarkFlagsMutuallyExclusive("stdout", "output")
+	huffCmd.Flags().BoolVarP(&recursiveFlag, "recursive", "r", false, "process every file in directories and their subdirectories")
+	huffCmd.Flags().StringSliceVar(&includeFlag, "include", nil, "with -r, only process files whose names match one of these glob patterns")
+	huffCmd.Flags().StringSliceVar(&excludeFlag, "exclude", nil, "with -r, skip files whose names match any of these glob patterns")
+	huffCmd.Flags().BoolVarP(&keepFlag, "keep", "k", true, "keep the input file (the default)")
+	huffCmd.Flags().BoolVar(&rmFlag, "rm", false,
+		"remove the input file once the output has been written in full and checked")
+	huffCmd.MarkFlagsMutuallyExclusive("keep", "rm")
+	huffCmd.Flags().BoolVarP(&noNameFlag, "no-name", "n", false,
+		"do not store the file's name, permissions and modification time, so the output only depends on its contents")
+	huffCmd.Flags().StringVar(&alphabetFlag, "alphabet", "auto",
+		"symbols to code: runes (UTF-8 characters), bytes, or auto to pick bytes for input that is not valid UTF-8")
+	huffCmd.Flags().StringVar(&blockSizeFlag, "block-size", "1MiB",
+		"bytes of input compressed with each code table, e.g. 256KiB; larger blocks use more memory")
+	huffCmd.Flags().IntVar(&threadsFlag, "threads", 0,
+		"number of blocks to compress at once, 0 to use all CPUs")
+	huffCmd.Flags().BoolVar(&blockChecksumsFlag, "block-checksums", false,
+		"store a checksum with every block, so a corrupt block is found before it is written out")
+	huffCmd.Flags().StringVar(&modeFlag, "mode", "static",
+		"static to store a code table with every block, or adaptive to update the codes after every symbol instead")
+	huffCmd.Flags().StringVar(&methodFlag, "method", "huffman",
+		"huffman to code symbols directly, bwt for a bzip2-style Burrows-Wheeler, move-to-front and run-length pipeline that suits text, or context to code each symbol with a table chosen by the one before it")
+	huffCmd.Flags().IntVarP(&levelFlag, "level", "l", 0,
+		"1 to 9 to replace repeated strings with LZ77 matches before Huffman coding, searching harder at higher levels; 0 for Huffman coding alone")
+	huffCmd.Flags().StringVar(&windowFlag, "window", "",
+		"with --level, how far back to look for repeated strings, e.g. 64KiB; from 256B to 4MiB, 32KiB by default")
+	huffCmd.Flags().IntVar(&maxCodeLengthFlag, "max-code-length", 0,
+		"longest code allowed in bits, e.g. 11, 12 or 15, so decoders can use small fixed tables; 0 for no limit")
+	huffCmd.Flags().StringVar(&dictFlag, "dict", "",
+		"code blocks with this dictionary's code table where that is smaller than storing their own (see train)")
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
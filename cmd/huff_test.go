@@ -2,60 +2,472 @@ package cmd
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
 )
 
 func TestHuff(t *testing.T) {
 	testFileName := "testfile.txt"
 	compressedTestFileName := "testfile.huff"
-	decodedTestFileName := "testfile_decoded.txt"
-	testFile, err := os.Create(testFileName)
-	if err != nil {
+	originalTestFileName := "testfile_original.txt"
+	defer os.Remove(testFileName)
+	defer os.Remove(compressedTestFileName)
+	defer os.Remove(originalTestFileName)
+
+	// Test on an empty file and on text. The original is removed after compressing,
+	// since unhuff restores it under the name stored in the .huff file.
+	for i, testContent := range []string{"", "ABRACADABRA\nalakazam\n! : åßˆ\n\n"} {
+		for _, name := range []string{testFileName, originalTestFileName} {
+			if err := os.WriteFile(name, []byte(testContent), 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		huffCmd := NewHuffCmd(testFileName)
+		if err := huffCmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(testFileName); err != nil {
+			log.Fatal(err)
+		}
+
+		unhuffCmd := NewUnhuffCmd(compressedTestFileName)
+		if err := unhuffCmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !deepCompare(originalTestFileName, testFileName) {
+			t.Errorf("Test Case %d failed. Input file not equal to decoded file.", i+1)
+		}
+		if err := os.Remove(compressedTestFileName); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	testFileName := "overwrite.txt"
+	compressedTestFileName := "overwrite.huff"
+	defer os.Remove(testFileName)
+	defer os.Remove(compressedTestFileName)
+	if err := os.WriteFile(testFileName, []byte("ABRACADABRA"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(compressedTestFileName, []byte("existing"), 0644); err != nil {
 		log.Fatal(err)
 	}
 
-	// Test on empty file
-	huffCmd := NewHuffCmd(testFileName)
-	huffCmd.Execute()
+	// Existing files are left alone unless overwriting is allowed
+	e := huffmyfile.Encoder{}
+	if err := e.EncodeToDefaultOutputFile(testFileName); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Test Case 1 failed. Expected fs.ErrExist, got %v", err)
+	}
+	if content, _ := os.ReadFile(compressedTestFileName); string(content) != "existing" {
+		t.Errorf("Test Case 1 failed. Existing file was changed.")
+	}
+	e.Overwrite = true
+	if err := e.EncodeToDefaultOutputFile(testFileName); err != nil {
+		t.Errorf("Test Case 2 failed. Expected no error, got %v", err)
+	}
+
+	// Decoding over the original, or the input itself, is refused even then
+	e.Overwrite = false
+	if err := e.DecodeToDefaultOutputFile(compressedTestFileName); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Test Case 3 failed. Expected fs.ErrExist, got %v", err)
+	}
+	e.Overwrite = true
+	if err := huffmyfile.Decode(compressedTestFileName, compressedTestFileName, &e); err == nil {
+		t.Errorf("Test Case 4 failed. Expected an error decoding a file onto itself.")
+	}
+	if err := e.DecodeToDefaultOutputFile(compressedTestFileName); err != nil {
+		t.Errorf("Test Case 5 failed. Expected no error, got %v", err)
+	}
+	if content, _ := os.ReadFile(testFileName); string(content) != "ABRACADABRA" {
+		t.Errorf("Test Case 5 failed. Decoded file not equal to input.")
+	}
+}
 
-	unhuffCmd := NewUnhuffCmd(compressedTestFileName)
-	unhuffCmd.Execute()
+func TestRemoveInput(t *testing.T) {
+	testFileName := "remove.txt"
+	compressedTestFileName := "remove.huff"
+	defer os.Remove(testFileName)
+	defer os.Remove(compressedTestFileName)
+	if err := os.WriteFile(testFileName, []byte("ABRACADABRA"), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	// Each command removes its input once the output is written
+	e := huffmyfile.Encoder{RemoveInput: true}
+	if err := e.EncodeToDefaultOutputFile(testFileName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(testFileName); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Test Case 1 failed. Input file was not removed.")
+	}
+	if err := e.DecodeToDefaultOutputFile(compressedTestFileName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(compressedTestFileName); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Test Case 2 failed. Compressed file was not removed.")
+	}
+	if content, _ := os.ReadFile(testFileName); string(content) != "ABRACADABRA" {
+		t.Errorf("Test Case 2 failed. Decoded file not equal to input.")
+	}
 
-	if !deepCompare(testFileName, decodedTestFileName) {
-		t.Errorf("Test Case 1 failed. Input file not equal to decoded file.")
+	// The input is kept if the output could not be written
+	if err := os.WriteFile(compressedTestFileName, []byte("existing"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := e.EncodeToDefaultOutputFile(testFileName); err == nil {
+		t.Errorf("Test Case 3 failed. Expected an error.")
+	}
+	if _, err := os.Stat(testFileName); err != nil {
+		t.Errorf("Test Case 3 failed. Input file was removed.")
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt":          "ABRACADABRA",
+		"sub/b.txt":      "alakazam",
+		"sub/c.log":      "! : åßˆ",
+		"sub/deep/d.txt": "",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Test that directories are only walked with -r, and filters apply
+	if _, err := collectFiles([]string{dir}, false, nil, nil, notHuffFile); err == nil {
+		t.Errorf("Test Case 1 failed. Expected an error for a directory without -r.")
+	}
+	fileNames, err := collectFiles([]string{dir}, true, []string{"*.txt", "*.log"}, []string{"b.*"}, notHuffFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "a.txt"), filepath.Join(dir, "sub/c.log"), filepath.Join(dir, "sub/deep/d.txt")}
+	if strings.Join(fileNames, ",") != strings.Join(expected, ",") {
+		t.Errorf("Test Case 2 failed. Expected %v, got %v", expected, fileNames)
+	}
+
+	// Compress the whole tree removing the originals, then restore it
+	var out bytes.Buffer
+	fileNames, _ = collectFiles([]string{dir}, true, nil, nil, notHuffFile)
+	if err := compressFiles(&out, fileNames, &huffmyfile.Encoder{RemoveInput: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Total: 4 files, 29 -> ") {
+		t.Errorf("Test Case 3 failed. Unexpected output %q", out.String())
+	}
+	fileNames, _ = collectFiles([]string{dir}, true, nil, nil, isHuffFile)
+	if len(fileNames) != len(files) {
+		t.Errorf("Test Case 3 failed. Expected %d .huff files, got %v", len(files), fileNames)
+	}
+	if err := decompressFiles(io.Discard, fileNames, &huffmyfile.Encoder{RemoveInput: true}); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != content {
+			t.Errorf("Test Case 4 failed. %s not equal to input.", name)
+		}
+	}
+}
+
+func TestRecursiveCollision(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "a.log", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("ABRACADABRA"), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// a.txt and a.log would both become a.huff, so nothing is compressed
+	fileNames, _ := collectFiles([]string{dir}, true, nil, nil, notHuffFile)
+	err := compressFiles(io.Discard, fileNames, &huffmyfile.Encoder{})
+	if err == nil || !strings.Contains(err.Error(), "a.huff") {
+		t.Errorf("Test Case 1 failed. Expected an error naming a.huff, got %v", err)
+	}
+	if huffFiles, _ := collectFiles([]string{dir}, true, nil, nil, isHuffFile); len(huffFiles) != 0 {
+		t.Errorf("Test Case 2 failed. Expected no .huff files, got %v", huffFiles)
+	}
+}
+
+func TestPackUnpack(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"fixtures/a.txt":          "ABRACADABRA",
+		"fixtures/sub/b.txt":      "alakazam",
+		"fixtures/sub/deep/c.txt": "",
+	}
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	testContent := "ABRACADABRA\nalakazam\n! : åßˆ\n\n"
-	testFile.WriteString(testContent)
+	archiveName := filepath.Join(dir, "fixtures.huffa")
+	var out bytes.Buffer
+	if err := packFiles(&out, archiveName, []string{filepath.Join(dir, "fixtures")}, huffmyfile.Options{}, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out.String(), "Packed 3 files") {
+		t.Errorf("Test Case 1 failed. Unexpected output %q", out.String())
+	}
+	if err := packFiles(io.Discard, archiveName, []string{filepath.Join(dir, "fixtures")}, huffmyfile.Options{}, false); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Test Case 2 failed. Expected the archive not to be replaced, got %v", err)
+	}
 
-	huffCmd = NewHuffCmd(testFileName)
-	huffCmd.Execute()
+	out.Reset()
+	if err := listArchive(&out, archiveName); err != nil {
+		t.Fatal(err)
+	}
+	for name := range files {
+		if !strings.Contains(out.String(), name) {
+			t.Errorf("Test Case 3 failed. %s not listed in %q", name, out.String())
+		}
+	}
 
-	unhuffCmd = NewUnhuffCmd(compressedTestFileName)
-	unhuffCmd.Execute()
+	// Extract a single directory, then everything
+	outDir := filepath.Join(dir, "out")
+	if err := unpackFiles(io.Discard, archiveName, outDir, []string{"fixtures/sub"}, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "fixtures/a.txt")); err == nil {
+		t.Errorf("Test Case 4 failed. Extracted an entry that was not asked for.")
+	}
+	if err := unpackFiles(io.Discard, archiveName, outDir, []string{"fixtures/missing"}, false, nil); err == nil {
+		t.Errorf("Test Case 4 failed. Expected an error for a missing entry.")
+	}
+	if err := unpackFiles(io.Discard, archiveName, outDir, nil, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if got, _ := os.ReadFile(filepath.Join(outDir, name)); string(got) != content {
+			t.Errorf("Test Case 5 failed. %s not equal to input.", name)
+		}
+	}
 
-	if !deepCompare(testFileName, decodedTestFileName) {
-		t.Errorf("Test Case 2 failed. Input file not equal to decoded file.")
+	// Entries packed with a dictionary need it to be unpacked
+	dict, err := huffmyfile.TrainDictionary([][]byte{[]byte("ABRACADABRA alakazam")}, huffmyfile.AlphabetRunes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := packFiles(io.Discard, archiveName, []string{filepath.Join(dir, "fixtures")}, huffmyfile.Options{Dictionary: dict}, true); err != nil {
+		t.Fatal(err)
+	}
+	dictDir := filepath.Join(dir, "dict")
+	if err := unpackFiles(io.Discard, archiveName, dictDir, nil, false, nil); err == nil {
+		t.Errorf("Test Case 6 failed. Expected an error unpacking without the dictionary.")
+	}
+	if err := unpackFiles(io.Discard, archiveName, dictDir, nil, true, dict); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if got, _ := os.ReadFile(filepath.Join(dictDir, name)); string(got) != content {
+			t.Errorf("Test Case 6 failed. %s not equal to input.", name)
+		}
 	}
+}
 
-	// Remove test files
-	err = os.Remove(testFileName)
+func TestTrain(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i < 20; i++ {
+		name := filepath.Join(dir, "samples", strings.Repeat("x", i+1)+".json")
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := os.WriteFile(name, []byte(`{"user":"alice","ok":true}`), 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+	dictName := filepath.Join(dir, "json.huffd")
+	var out bytes.Buffer
+	if err := trainDictionary(&out, dictName, []string{filepath.Join(dir, "samples")}, huffmyfile.AlphabetAuto, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "on 20 files") {
+		t.Errorf("Test Case 1 failed. Unexpected output %q", out.String())
+	}
+	dict, err := loadDictionary(dictName)
 	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compress a message with the dictionary, then decompress it with and without
+	inputFileName := filepath.Join(dir, "message.json")
+	os.WriteFile(inputFileName, []byte(`{"user":"bob","ok":false}`), 0644)
+	e := huffmyfile.Encoder{Quiet: true, Options: huffmyfile.Options{Dictionary: dict}}
+	if err := huffmyfile.Encode(inputFileName, inputFileName+".huff", &e); err != nil {
+		t.Fatal(err)
+	}
+	if err := huffmyfile.Verify(inputFileName+".huff", &huffmyfile.Encoder{}); !errors.Is(err, huffmyfile.ErrDictionary) {
+		t.Errorf("Test Case 2 failed. Expected a dictionary error, got %v", err)
+	}
+	if err := huffmyfile.Decode(inputFileName+".huff", filepath.Join(dir, "decoded.json"), &e); err != nil {
+		t.Fatal(err)
+	}
+	if !deepCompare(inputFileName, filepath.Join(dir, "decoded.json")) {
+		t.Errorf("Test Case 3 failed. Decoded file not equal to input.")
+	}
+}
+
+func TestUnhuffErrors(t *testing.T) {
+	// Test on file without .huff extension
+	unhuffCmd := NewUnhuffCmd("testfile.txt")
+	if err := unhuffCmd.Execute(); !errors.Is(err, huffmyfile.ErrNotHuffFile) {
+		t.Errorf("Test Case 1 failed. Expected ErrNotHuffFile, got %v", err)
+	}
+
+	// Test on .huff files with a truncated code table, an unknown format version
+	// and no header at all
+	testCases := []struct {
+		content  string
+		expected error
+	}{
+		{"HUFF\x01\x01\x00\x01\x05\x02", huffmyfile.ErrCorruptInput},
+		{"HUFF\x09\x00\x00\x00", huffmyfile.ErrUnsupportedVersion},
+		{"65 0 66 1\n\xff", huffmyfile.ErrNotHuffFile},
+	}
+	corruptTestFileName := "corrupt.huff"
+	defer os.Remove(corruptTestFileName)
+
+	for i, tc := range testCases {
+		err := os.WriteFile(corruptTestFileName, []byte(tc.content), 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		unhuffCmd = NewUnhuffCmd(corruptTestFileName)
+		err = unhuffCmd.Execute()
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("Test Case %d failed. Expected %v, got %v", i+2, tc.expected, err)
+		}
+		if exitCode(err) != exitBadFormat {
+			t.Errorf("Test Case %d failed. Expected exit code %d, got %d", i+2, exitBadFormat, exitCode(err))
+		}
+
+		// Neither the output nor its temporary file should be left behind
+		tempFiles, _ := filepath.Glob(".corrupt_decoded.txt.*.tmp")
+		if _, err := os.Stat("corrupt_decoded.txt"); err == nil || len(tempFiles) > 0 {
+			t.Errorf("Test Case %d failed. Output was left behind.", i+2)
+			os.Remove("corrupt_decoded.txt")
+		}
+	}
+}
+
+func TestTestFiles(t *testing.T) {
+	var buf bytes.Buffer
+	zw := huffmyfile.NewWriter(&buf)
+	zw.Write([]byte("ABRACADABRA\nalakazam\n"))
+	zw.Close()
+	compressed := buf.Bytes()
+	corrupt := append([]byte(nil), compressed...)
+	corrupt[len(corrupt)-1] ^= 1
+
+	goodTestFileName, corruptTestFileName := "good.huff", "corrupt.huff"
+	defer os.Remove(goodTestFileName)
+	defer os.Remove(corruptTestFileName)
+	if err := os.WriteFile(goodTestFileName, compressed, 0644); err != nil {
 		log.Fatal(err)
-		return
 	}
-	err = os.Remove(compressedTestFileName)
-	if err != nil {
+	if err := os.WriteFile(corruptTestFileName, corrupt, 0644); err != nil {
 		log.Fatal(err)
-		return
 	}
-	err = os.Remove(decodedTestFileName)
-	if err != nil {
+
+	// Test on an intact file
+	var out bytes.Buffer
+	if err := testFiles(&out, []string{goodTestFileName}, &huffmyfile.Encoder{}); err != nil {
+		t.Errorf("Test Case 1 failed. Expected no error, got %v", err)
+	}
+	if out.String() != "good.huff: OK\n" {
+		t.Errorf("Test Case 1 failed. Unexpected output %q", out.String())
+	}
+
+	// Test on an intact and a corrupt file
+	out.Reset()
+	err := testFiles(&out, []string{goodTestFileName, corruptTestFileName}, &huffmyfile.Encoder{})
+	if !errors.Is(err, huffmyfile.ErrChecksum) || exitCode(err) != exitBadFormat {
+		t.Errorf("Test Case 2 failed. Expected ErrChecksum, got %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "good.huff: OK\ncorrupt.huff: FAILED") {
+		t.Errorf("Test Case 2 failed. Unexpected output %q", out.String())
+	}
+}
+
+func TestPrintInfo(t *testing.T) {
+	var buf bytes.Buffer
+	zw := huffmyfile.NewWriter(&buf)
+	zw.Write([]byte("ABRACADABRA\n"))
+	zw.Close()
+	infoTestFileName := "info.huff"
+	defer os.Remove(infoTestFileName)
+	if err := os.WriteFile(infoTestFileName, buf.Bytes(), 0644); err != nil {
 		log.Fatal(err)
-		return
+	}
+
+	var out bytes.Buffer
+	if err := printInfo(&out, infoTestFileName, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"Original size:    12 bytes\n",
+		"Symbols:          12 coded, 6 distinct\n",
+		"   1 bits: 1\n",
+		"'A'     5          0\n",
+		"'\\n'    1          1100\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in output:\n%s", expected, out.String())
+		}
+	}
+
+	// Context-coded files say so in the header summary
+	buf.Reset()
+	zw = huffmyfile.NewWriter(&buf)
+	zw.Method = huffmyfile.MethodContext
+	zw.Write([]byte("ABRACADABRA\n"))
+	zw.Close()
+	if err := os.WriteFile(infoTestFileName, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	out.Reset()
+	if err := printInfo(&out, infoTestFileName, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Method:           context\n") {
+		t.Errorf("Expected %q in output:\n%s", "Method:           context\n", out.String())
+	}
+
+	// Empty input has no blocks, so only the header is shown
+	buf.Reset()
+	zw = huffmyfile.NewWriter(&buf)
+	zw.Close()
+	if err := os.WriteFile(infoTestFileName, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	out.Reset()
+	if err := printInfo(&out, infoTestFileName, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Blocks:           0\n") || strings.Contains(out.String(), "Block 1") {
+		t.Errorf("Expected a header without blocks in output:\n%s", out.String())
 	}
 }
 
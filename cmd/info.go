@@ -0,0 +1,218 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
+
+	"github.com/spf13/cobra"
+)
+
+// infoCmd represents the info command
+var infoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Shows the header and code tables of a .huff file. Usage: `huffmyfile info [FILE]`",
+	Long: `Shows the header and code tables of a .huff file. Usage: ` + "`huffmyfile info [FILE]`" + `
+
+Prints the format version, sizes, number of symbols and how many bits per symbol the
+codes take compared to the Shannon entropy of the symbol frequencies, followed by the
+code length histogram and code table of the first block. Use --block to show another
+block, or --block 0 to show every block.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dict, err := loadDictionary(dictFlag)
+		if err != nil {
+			return err
+		}
+		return printInfo(os.Stdout, args[0], blockFlag, dict)
+	},
+}
+
+// Flags for the info command
+var blockFlag int
+
+// printInfo writes a description of the .huff file to w, including the code table of
+// the given block, numbered from 1, or of all blocks if block is 0. Files of empty
+// input have no blocks, so only their header is described. dict is only needed for
+// files compressed with a dictionary.
+func printInfo(w io.Writer, fileName string, block int, dict *huffmyfile.Dictionary) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	info, err := huffmyfile.Inspect(f, dict)
+	if err != nil {
+		return err
+	}
+	if block < 0 || block > len(info.Blocks) && len(info.Blocks) > 0 {
+		return fmt.Errorf("block %d does not exist, the file has %d blocks", block, len(info.Blocks))
+	}
+
+	symbols, distinct := 0, make(map[int]bool)
+	codedBits, entropyBits := 0, 0.0
+	matches, matchedBytes := 0, 0
+	for i := range info.Blocks {
+		b := &info.Blocks[i]
+		symbols += b.Symbols()
+		matches += b.Matches
+		matchedBytes += b.MatchedBytes
+		codedBits += b.CodedBits()
+		entropyBits += b.EntropyBits()
+		for _, codes := range b.Tables() {
+			for _, c := range codes {
+				if c.IsLiteral() && c.Frequency > 0 {
+					distinct[c.Symbol] = true
+				}
+			}
+		}
+	}
+
+	size := info.DecodedSize()
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "File:\t%s\n", fileName)
+	fmt.Fprintf(tw, "Format version:\t%d\n", info.Version)
+	if info.Name != "" {
+		fmt.Fprintf(tw, "Original name:\t%s\n", info.Name)
+	}
+	if info.Mode != 0 {
+		fmt.Fprintf(tw, "Permissions:\t%v\n", info.Mode)
+	}
+	if !info.ModTime.IsZero() {
+		fmt.Fprintf(tw, "Modified:\t%v\n", info.ModTime.Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "Alphabet:\t%v\n", info.Alphabet)
+	fmt.Fprintf(tw, "Block checksums:\t%v\n", info.BlockChecksums)
+	if info.Adaptive {
+		fmt.Fprintf(tw, "Coding:\t%v\n", huffmyfile.CodingAdaptive)
+	}
+	if info.BWT {
+		fmt.Fprintf(tw, "Method:\t%v\n", huffmyfile.MethodBWT)
+	}
+	if info.Contexts {
+		fmt.Fprintf(tw, "Method:\t%v\n", huffmyfile.MethodContext)
+	}
+	if info.Level != 0 {
+		fmt.Fprintf(tw, "LZ77:\tlevel %d, %d byte window\n", info.Level, info.Window)
+	}
+	if info.MaxCodeLength != 0 {
+		fmt.Fprintf(tw, "Max code length:\t%d bits\n", info.MaxCodeLength)
+	}
+	if info.DictionaryID != 0 {
+		fmt.Fprintf(tw, "Dictionary:\t%08x\n", info.DictionaryID)
+	}
+	fmt.Fprintf(tw, "Original size:\t%d bytes\n", size)
+	fmt.Fprintf(tw, "Compressed size:\t%d bytes", fileInfo.Size())
+	if size > 0 {
+		fmt.Fprintf(tw, " (%.2f%% of original)", float64(fileInfo.Size())/float64(size)*100)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintf(tw, "Blocks:\t%d\n", len(info.Blocks))
+	fmt.Fprintf(tw, "Symbols:\t%d coded, %d distinct\n", symbols, len(distinct))
+	if info.Level != 0 {
+		fmt.Fprintf(tw, "Matches:\t%d", matches)
+		if size > 0 {
+			fmt.Fprintf(tw, ", covering %d bytes (%.2f%% of original)", matchedBytes, float64(matchedBytes)/float64(size)*100)
+		}
+		fmt.Fprintln(tw)
+	}
+	if symbols > 0 {
+		fmt.Fprintf(tw, "Bits per symbol:\t%.3f, %.3f including code tables and headers\n",
+			float64(codedBits)/float64(symbols), float64(fileInfo.Size()*8)/float64(symbols))
+		//	The entropy of literals and lengths leaves out distances, so it is not
+		//	comparable for LZ77
+		if info.Level == 0 {
+			fmt.Fprintf(tw, "Entropy:\t%.3f bits per symbol\n", entropyBits/float64(symbols))
+		}
+	}
+	tw.Flush()
+
+	for i := range info.Blocks {
+		if block != 0 && i+1 != block {
+			continue
+		}
+		b := &info.Blocks[i]
+		fmt.Fprintf(w, "\nBlock %d: %d bytes, %d encoded", i+1, b.Size, b.EncodedSize)
+		if b.UsesDictionary {
+			fmt.Fprint(w, ", coded with the dictionary")
+		}
+		if len(b.ContextTables) > 0 {
+			fmt.Fprintf(w, ", %d code tables", len(b.ContextTables))
+		}
+		fmt.Fprintln(w)
+
+		if b.Adaptive {
+			fmt.Fprintln(w, "\nSymbols (codes adapt after every symbol):")
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "Symbol\tFrequency")
+			for _, c := range b.Codes {
+				fmt.Fprintf(tw, "%s\t%d\n", c.Name, c.Frequency)
+			}
+			tw.Flush()
+			continue
+		}
+
+		fmt.Fprintln(w, "\nCode lengths:")
+		for length, n := range b.CodeLengthHistogram() {
+			if n > 0 {
+				fmt.Fprintf(w, "%4d bits: %d\n", length, n)
+			}
+		}
+
+		if len(b.ContextTables) > 0 {
+			for j, t := range b.ContextTables {
+				fmt.Fprintf(w, "\nCodes of table %d, after %s:\n", j+1, strings.Join(t.Contexts, " "))
+				printCodes(w, t.Codes)
+			}
+			continue
+		}
+
+		fmt.Fprintln(w, "\nCodes:")
+		printCodes(w, b.Codes)
+
+		if len(b.DistanceCodes) > 0 {
+			fmt.Fprintln(w, "\nDistance codes:")
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "Distance\tFrequency\tCode")
+			for _, c := range b.DistanceCodes {
+				fmt.Fprintf(tw, "%s\t%d\t%s\n", strings.TrimPrefix(c.Name, "distance "), c.Frequency, c.Code)
+			}
+			tw.Flush()
+		}
+	}
+	return nil
+}
+
+// printCodes writes a table of codes with the frequency of each symbol to w
+func printCodes(w io.Writer, codes []huffmyfile.SymbolCode) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Symbol\tFrequency\tCode")
+	for _, c := range codes {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", c.Name, c.Frequency, c.Code)
+	}
+	tw.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(infoCmd)
+
+	infoCmd.Flags().IntVar(&blockFlag, "block", 1, "block whose code table to show, or 0 for every block")
+	infoCmd.Flags().StringVar(&dictFlag, "dict", "", "dictionary the file was compressed with")
+}
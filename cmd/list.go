@@ -0,0 +1,66 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the files in a .huffa archive. Usage: `huffmyfile list ARCHIVE`",
+	Long: `Lists the files in a .huffa archive. Usage: ` + "`huffmyfile list ARCHIVE`" + `
+
+Shows the size, compressed size, permissions, modification time and name of every
+entry, read from the archive's directory without decoding any of them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listArchive(os.Stdout, args[0])
+	},
+}
+
+// listArchive writes a table of the entries in an archive to w, followed by the
+// totals.
+func listArchive(w io.Writer, archiveName string) error {
+	archive, f, err := openArchive(archiveName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var compressedTotal, total int64
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Size\tCompressed\tRatio\tPermissions\tModified\tName")
+	for _, entry := range archive.Entries {
+		ratio := "-"
+		if entry.Size > 0 {
+			ratio = fmt.Sprintf("%.2f%%", float64(entry.CompressedSize)/float64(entry.Size)*100)
+		}
+		modified := "-"
+		if !entry.ModTime.IsZero() {
+			modified = entry.ModTime.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%v\t%s\t%s\n", entry.Size, entry.CompressedSize, ratio, entry.Mode, modified, entry.Name)
+		compressedTotal += entry.CompressedSize
+		total += entry.Size
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Total: %d files, %s\n", len(archive.Entries), sizes(total, compressedTotal))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+}
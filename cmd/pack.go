@@ -0,0 +1,122 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
+
+	"github.com/spf13/cobra"
+)
+
+// packCmd represents the pack command
+var packCmd = &cobra.Command{
+	Use:   "pack",
+	Short: "Bundles files into a .huffa archive. Usage: `huffmyfile pack ARCHIVE FILE...`",
+	Long: `Bundles files into a .huffa archive. Usage: ` + "`huffmyfile pack ARCHIVE FILE...`" + `
+
+Each file is compressed with its own code tables and stored along with its
+permissions and modification time. Directories are packed with every file in them
+and their subdirectories, filtered by --include and --exclude. Entries are named by
+their path relative to the directory containing each FILE, so packing fixtures/
+stores fixtures/a.txt and so on. An existing archive is only replaced with -f.
+Entries compressed with --dict need the same dictionary to be unpacked.`,
+	Args: cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dict, err := loadDictionary(dictFlag)
+		if err != nil {
+			return err
+		}
+		options := huffmyfile.Options{Threads: threadsFlag, Dictionary: dict}
+		return packFiles(os.Stdout, args[0], args[1:], options, forceFlag)
+	},
+}
+
+// packFiles writes the files named by args, and every file in directories among
+// them, to a new archive, then reports the total sizes on w.
+func packFiles(w io.Writer, archiveName string, args []string, options huffmyfile.Options, overwrite bool) error {
+	var fileNames, entryNames []string
+	stored := make(map[string]string)
+	archiveInfo, archiveErr := os.Stat(archiveName)
+	for _, arg := range args {
+		names, err := collectFiles([]string{arg}, true, includeFlag, excludeFlag, nil)
+		if err != nil {
+			return err
+		}
+		//	Entries are named relative to the directory containing arg, unless that
+		//	would put them outside it
+		root := filepath.Dir(filepath.Clean(arg))
+		if filepath.Base(arg) == ".." {
+			root = arg
+		}
+		for _, fileName := range names {
+			//	An archive being replaced is not packed into itself
+			if info, err := os.Stat(fileName); archiveErr == nil && err == nil && os.SameFile(info, archiveInfo) {
+				continue
+			}
+			rel, err := filepath.Rel(root, fileName)
+			if err != nil {
+				return err
+			}
+			entryName := filepath.ToSlash(rel)
+			if other, ok := stored[entryName]; ok {
+				return fmt.Errorf("%s and %s would both be stored as %s", other, fileName, entryName)
+			}
+			stored[entryName] = fileName
+			fileNames = append(fileNames, fileName)
+			entryNames = append(entryNames, entryName)
+		}
+	}
+
+	f, err := huffmyfile.CreateOutputFile(archiveName, overwrite)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	archive := huffmyfile.NewArchiveWriter(f)
+	archive.Options = options
+	var inputTotal int64
+	for i, fileName := range fileNames {
+		if err := archive.AddFile(fileName, entryNames[i]); err != nil {
+			return err
+		}
+		if info, err := os.Stat(fileName); err == nil {
+			inputTotal += info.Size()
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	outputInfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Packed %d files into %s: %s\n", len(fileNames), archiveName, sizes(inputTotal, outputInfo.Size()))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(packCmd)
+
+	packCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace the archive if it already exists")
+	packCmd.Flags().StringSliceVar(&includeFlag, "include", nil, "only pack files in directories whose names match one of these glob patterns")
+	packCmd.Flags().StringSliceVar(&excludeFlag, "exclude", nil, "skip files in directories whose names match any of these glob patterns")
+	packCmd.Flags().IntVar(&threadsFlag, "threads", 0,
+		"number of blocks to compress at once, 0 to use all CPUs")
+	packCmd.Flags().StringVar(&dictFlag, "dict", "",
+		"code entries with this dictionary's code table where that is smaller than storing their own (see train)")
+}
@@ -9,11 +9,22 @@ https://opensource.org/licenses/MIT.
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 
+	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
+
 	"github.com/spf13/cobra"
 )
 
+// Exit codes returned by the CLI
+const (
+	exitError     = 1 // General failure, e.g. a file could not be opened or written
+	exitBadFormat = 2 // The input is not a .huff file, archive or dictionary, or is corrupt
+)
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "huffmyfile",
@@ -21,6 +32,8 @@ var rootCmd = &cobra.Command{
 	Long: `HuffMyFile is a CLI tool for compressing and decompressing .txt files.
 Compression is done using the Huffman coding algorithm, hence the name of the tool!
 To learn more, visit the repository at github.com/martin-coder/huffmyfile`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -28,8 +41,27 @@ To learn more, visit the repository at github.com/martin-coder/huffmyfile`,
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		if errors.Is(err, fs.ErrExist) {
+			fmt.Fprintln(os.Stderr, "Error:", err, "(use -f to overwrite)")
+		} else {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode returns the process exit status for an error returned by a command
+func exitCode(err error) int {
+	if errors.Is(err, huffmyfile.ErrCorruptInput) ||
+		errors.Is(err, huffmyfile.ErrChecksum) ||
+		errors.Is(err, huffmyfile.ErrEmptyCodeTable) ||
+		errors.Is(err, huffmyfile.ErrNotHuffFile) ||
+		errors.Is(err, huffmyfile.ErrNotArchive) ||
+		errors.Is(err, huffmyfile.ErrNotDictionary) ||
+		errors.Is(err, huffmyfile.ErrUnsupportedVersion) {
+		return exitBadFormat
 	}
+	return exitError
 }
 
 func init() {
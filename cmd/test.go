@@ -0,0 +1,70 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
+
+	"github.com/spf13/cobra"
+)
+
+// testCmd represents the test command
+var testCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Checks that .huff files are intact without writing any output. Usage: `huffmyfile test [FILE]...`",
+	Long: `Checks that .huff files are intact without writing any output. Usage: ` + "`huffmyfile test [FILE]...`" + `
+
+Every file is fully decoded, checking its header, code tables and checksums, and
+reported as OK or FAILED. The exit status is 0 only if every file is OK.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		e := huffmyfile.Encoder{}
+		e.Threads = threadsFlag
+		dict, err := loadDictionary(dictFlag)
+		if err != nil {
+			return err
+		}
+		e.Dictionary = dict
+		return testFiles(os.Stdout, args, &e)
+	},
+}
+
+// testFiles verifies each file, reporting the result for each on w. Returns an error
+// wrapping the first failure if any file failed.
+func testFiles(w io.Writer, fileNames []string, e *huffmyfile.Encoder) error {
+	var firstErr error
+	failed := 0
+	for _, fileName := range fileNames {
+		if err := huffmyfile.Verify(fileName, e); err != nil {
+			fmt.Fprintf(w, "%s: FAILED (%v)\n", fileName, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "%s: OK\n", fileName)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed: %w", failed, len(fileNames), firstErr)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+
+	testCmd.Flags().IntVar(&threadsFlag, "threads", 0,
+		"number of blocks to decompress at once, 0 to use all CPUs")
+	testCmd.Flags().StringVar(&dictFlag, "dict", "", "dictionary the files were compressed with")
+}
@@ -0,0 +1,111 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
+
+	"github.com/spf13/cobra"
+)
+
+// trainCmd represents the train command
+var trainCmd = &cobra.Command{
+	Use:   "train",
+	Short: "Builds a dictionary from sample files. Usage: `huffmyfile train -o DICT FILE...`",
+	Long: `Builds a dictionary from sample files. Usage: ` + "`huffmyfile train -o DICT FILE...`" + `
+
+Counts the symbols in every sample, and in every file in directories and their
+subdirectories, and saves a code table for their combined frequencies as a
+dictionary file. Files compressed with huff --dict DICT refer to the dictionary by
+its ID instead of storing their own code table, which helps most with many small
+files of the same kind, such as JSON messages. The same dictionary must be passed to
+unhuff --dict to decompress them.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		alphabet, err := huffmyfile.ParseAlphabet(alphabetFlag)
+		if err != nil {
+			return err
+		}
+		return trainDictionary(os.Stdout, outputFlag, args, alphabet, forceFlag)
+	},
+}
+
+// Flags shared by the huff, unhuff, test and info commands
+var dictFlag string
+
+// trainDictionary builds a dictionary from the files named by args and saves it to
+// outputFileName, reporting its ID on w.
+func trainDictionary(w io.Writer, outputFileName string, args []string, alphabet huffmyfile.Alphabet, overwrite bool) error {
+	fileNames, err := collectFiles(args, true, includeFlag, excludeFlag, nil)
+	if err != nil {
+		return err
+	}
+	var samples [][]byte
+	var total int64
+	for _, fileName := range fileNames {
+		data, err := os.ReadFile(fileName)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, data)
+		total += int64(len(data))
+	}
+	dict, err := huffmyfile.TrainDictionary(samples, alphabet)
+	if err != nil {
+		return err
+	}
+
+	f, err := huffmyfile.CreateOutputFile(outputFileName, overwrite)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	if _, err := dict.WriteTo(f); err != nil {
+		return err
+	}
+	if err := f.Commit(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "Trained dictionary %08x on %d files (%d bytes): %d %v symbols, saved to %s\n",
+		dict.ID, len(fileNames), total, dict.Symbols(), dict.Alphabet, outputFileName)
+	return nil
+}
+
+// loadDictionary reads the named dictionary file, or returns nil if name is empty
+func loadDictionary(name string) (*huffmyfile.Dictionary, error) {
+	if name == "" {
+		return nil, nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dict, err := huffmyfile.ReadDictionary(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return dict, nil
+}
+
+func init() {
+	rootCmd.AddCommand(trainCmd)
+
+	trainCmd.Flags().StringVarP(&outputFlag, "output", "o", "", "write the dictionary to this file")
+	trainCmd.MarkFlagRequired("output")
+	trainCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace the dictionary file if it already exists")
+	trainCmd.Flags().StringSliceVar(&includeFlag, "include", nil, "only use files in directories whose names match one of these glob patterns")
+	trainCmd.Flags().StringSliceVar(&excludeFlag, "exclude", nil, "skip files in directories whose names match any of these glob patterns")
+	trainCmd.Flags().StringVar(&alphabetFlag, "alphabet", "auto",
+		"symbols to code: runes (UTF-8 characters), bytes, or auto to pick bytes if any sample is not valid UTF-8")
+}
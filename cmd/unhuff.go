@@ -9,6 +9,9 @@ https://opensource.org/licenses/MIT.
 package cmd
 
 import (
+	"errors"
+	"os"
+
 	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
 
 	"github.com/spf13/cobra"
@@ -17,27 +20,90 @@ import (
 // unhuffCmd represents the unhuff command
 var unhuffCmd = &cobra.Command{
 	Use:   "unhuff",
-	Short: "Decompresses .huff files into .txt files. Usage: `huffmyfile unhuff [FILE]`",
-	Run: func(cmd *cobra.Command, args []string) {
-		e := huffmyfile.Encoder{}
-		e.DecodeToDefaultOutputFile(args[0])
+	Short: "Decompresses .huff files into .txt files. Usage: `huffmyfile unhuff [FILE]...`",
+	Long: `Decompresses .huff files into .txt files. Usage: ` + "`huffmyfile unhuff [FILE]...`" + `
+
+With -c, the output is written to standard output instead of a file, so it can be
+piped into another program. If FILE is -, standard input is decompressed to
+standard output. Otherwise the output file takes the original file name stored
+when it was compressed, unless -o is used to choose it, and its permissions and
+modification time are restored; existing files are only replaced with -f.
+
+With several files, or with -r to decompress every .huff file in the given
+directories and their subdirectories, each file is decompressed next to the .huff
+file, followed by a summary of the sizes of each and the totals.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		e := huffmyfile.Encoder{SkipCorruptBlocks: recoverFlag}
+		e.Threads = threadsFlag
+		dict, err := loadDictionary(dictFlag)
+		if err != nil {
+			return err
+		}
+		e.Dictionary = dict
+		e.Overwrite = forceFlag
+		e.NoName = noNameFlag
+		e.RemoveInput = rmFlag
+
+		if len(args) > 1 || recursiveFlag || isDir(args[0]) {
+			if stdoutFlag || outputFlag != "" {
+				return errors.New("-c and -o can only be used with a single file")
+			}
+			fileNames, err := collectFiles(args, recursiveFlag, includeFlag, excludeFlag, isHuffFile)
+			if err != nil {
+				return err
+			}
+			return decompressFiles(os.Stdout, fileNames, &e)
+		}
+
+		switch {
+		case (args[0] == "-" || stdoutFlag) && rmFlag:
+			return errors.New("--rm cannot be used when reading standard input or writing standard output")
+		case args[0] == "-" || stdoutFlag:
+			return streamFiles(args[0], outputFlag, e.DecodeStream)
+		case outputFlag != "":
+			return huffmyfile.Decode(args[0], outputFlag, &e)
+		}
+		return e.DecodeToDefaultOutputFile(args[0])
 	},
 }
 
+// Flags for the unhuff command
+var recoverFlag bool
+
 // Wrapper function to return an unhuff command for testing
 func NewUnhuffCmd(CompressedTestFileName string) *cobra.Command {
 	return &cobra.Command{
 		Use:   "unhuff",
 		Short: "Decompresses .huff files into .txt files. Usage: `huffmyfile unhuff [FILE]`",
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			e := huffmyfile.Encoder{}
-			e.DecodeToDefaultOutputFile(CompressedTestFileName)
+			return e.DecodeToDefaultOutputFile(CompressedTestFileName)
 		},
 	}
 }
 func init() {
 	rootCmd.AddCommand(unhuffCmd)
 
+	unhuffCmd.Flags().BoolVarP(&stdoutFlag, "stdout", "c", false, "write to standard output instead of a file")
+	unhuffCmd.Flags().StringVarP(&outputFlag, "output", "o", "", "write to the given file instead of the original file name")
+	unhuffCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace the output file if it already exists")
+	unhuffCmd.MarkFlagsMutuallyExclusive("stdout", "output")
+	unhuffCmd.Flags().BoolVarP(&recursiveFlag, "recursive", "r", false, "process every .huff file in directories and their subdirectories")
+	unhuffCmd.Flags().StringSliceVar(&includeFlag, "include", nil, "with -r, only process files whose names match one of these glob patterns")
+	unhuffCmd.Flags().StringSliceVar(&excludeFlag, "exclude", nil, "with -r, skip files whose names match any of these glob patterns")
+	unhuffCmd.Flags().BoolVarP(&keepFlag, "keep", "k", true, "keep the input file (the default)")
+	unhuffCmd.Flags().BoolVar(&rmFlag, "rm", false,
+		"remove the input file once the output has been written in full")
+	unhuffCmd.MarkFlagsMutuallyExclusive("keep", "rm")
+	unhuffCmd.Flags().BoolVarP(&noNameFlag, "no-name", "n", false,
+		"do not restore the original file name, permissions and modification time")
+	unhuffCmd.Flags().BoolVar(&recoverFlag, "recover", false,
+		"skip corrupt blocks and decode the rest of the file, still exiting with an error")
+	unhuffCmd.Flags().IntVar(&threadsFlag, "threads", 0,
+		"number of blocks to decompress at once, 0 to use all CPUs")
+	unhuffCmd.Flags().StringVar(&dictFlag, "dict", "", "dictionary the files were compressed with")
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
@@ -0,0 +1,146 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	huffmyfile "github.com/martin-coder/huffmyfile/pkg"
+
+	"github.com/spf13/cobra"
+)
+
+// unpackCmd represents the unpack command
+var unpackCmd = &cobra.Command{
+	Use:   "unpack",
+	Short: "Extracts files from a .huffa archive. Usage: `huffmyfile unpack ARCHIVE [NAME]...`",
+	Long: `Extracts files from a .huffa archive. Usage: ` + "`huffmyfile unpack ARCHIVE [NAME]...`" + `
+
+Extracts every entry, or only those named, into the current directory or the one
+given with -C, restoring their permissions and modification times. A NAME that is a
+directory in the archive extracts everything under it. Only the entries being
+extracted are decoded, and existing files are only replaced with -f.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dict, err := loadDictionary(dictFlag)
+		if err != nil {
+			return err
+		}
+		return unpackFiles(os.Stdout, args[0], directoryFlag, args[1:], forceFlag, dict)
+	},
+}
+
+// Flags for the unpack command
+var directoryFlag string
+
+// openArchive opens the named archive and reads its directory. The file must be
+// closed once the archive is no longer needed.
+func openArchive(archiveName string) (*huffmyfile.ArchiveReader, *os.File, error) {
+	f, err := os.Open(archiveName)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	archive, err := huffmyfile.NewArchiveReader(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", archiveName, err)
+	}
+	return archive, f, nil
+}
+
+// unpackFiles extracts the named entries of an archive, or all of them if there are
+// no names, into dir and reports each on w. Entries that fail are reported and
+// skipped. Returns an error wrapping the first failure if any entry failed. dict is
+// only needed for archives packed with a dictionary.
+func unpackFiles(w io.Writer, archiveName, dir string, names []string, overwrite bool, dict *huffmyfile.Dictionary) error {
+	archive, f, err := openArchive(archiveName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	archive.Dictionary = dict
+
+	var entries []huffmyfile.ArchiveEntry
+	for _, entry := range archive.Entries {
+		if len(names) == 0 || matchesEntry(entry.Name, names) {
+			entries = append(entries, entry)
+		}
+	}
+	for _, name := range names {
+		found := false
+		for _, entry := range entries {
+			found = found || matchesEntry(entry.Name, []string{name})
+		}
+		if !found {
+			return fmt.Errorf("%s: %s not found in archive", archiveName, name)
+		}
+	}
+
+	var firstErr error
+	var compressedTotal, total int64
+	failed := 0
+	for _, entry := range entries {
+		//	Entry names are checked to be relative and clean when they are read
+		outputFileName := filepath.Join(dir, filepath.FromSlash(entry.Name))
+		err := os.MkdirAll(filepath.Dir(outputFileName), 0777)
+		if err == nil {
+			err = archive.Extract(entry, outputFileName, overwrite)
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%s: FAILED (%v)\n", entry.Name, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "%s -> %s: %s\n", entry.Name, outputFileName, sizes(entry.CompressedSize, entry.Size))
+		compressedTotal += entry.CompressedSize
+		total += entry.Size
+	}
+
+	fmt.Fprintf(w, "Total: %d files, %s", len(entries)-failed, sizes(compressedTotal, total))
+	if failed > 0 {
+		fmt.Fprintf(w, ", %d failed", failed)
+	}
+	fmt.Fprintln(w)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed: %w", failed, len(entries), firstErr)
+	}
+	return nil
+}
+
+// matchesEntry reports whether an entry is one of names, or is under a directory
+// that is one of them
+func matchesEntry(entryName string, names []string) bool {
+	for _, name := range names {
+		name = strings.TrimSuffix(filepath.ToSlash(name), "/")
+		if entryName == name || strings.HasPrefix(entryName, name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(unpackCmd)
+
+	unpackCmd.Flags().StringVarP(&directoryFlag, "directory", "C", ".", "extract into this directory")
+	unpackCmd.Flags().BoolVarP(&forceFlag, "force", "f", false, "replace files that already exist")
+	unpackCmd.Flags().StringVar(&dictFlag, "dict", "", "dictionary the archive was packed with")
+}
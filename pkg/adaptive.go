@@ -0,0 +1,247 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Adaptive Huffman coding, using the FGK algorithm (Faller, Gallager and Knuth). The
+* encoder and decoder both start each block with a tree holding only a "not yet
+* transmitted" (NYT) leaf, and update it the same way after every symbol, so no code
+* table is stored and the input is only read once. A symbol's first occurrence is
+* coded as the NYT code followed by the symbol itself: 9 bits for bytes, and for runes
+* a 0 bit and 7 bits for ASCII or a 1 bit and 21 bits for anything else.
+*
+* The tree keeps the sibling property: numbering the nodes from the root down, weights
+* never increase, and siblings are numbered next to each other. Nodes are kept in an
+* array in this order. Incrementing a leaf's weight would break the property, so each
+* node on the way to the root is first swapped with the highest numbered node of the
+* same weight.
+ */
+
+package huffmyfile
+
+import (
+	"fmt"
+)
+
+/* Coding: Selects how symbols are coded by a Writer. */
+type Coding uint8
+
+const (
+	CodingStatic   Coding = iota // Each block stores a Huffman code built from its symbol frequencies
+	CodingAdaptive               // Codes adapt after every symbol, and blocks store no code table
+)
+
+func (m Coding) String() string {
+	switch m {
+	case CodingStatic:
+		return "static"
+	case CodingAdaptive:
+		return "adaptive"
+	}
+	return fmt.Sprintf("Coding(%d)", uint8(m))
+}
+
+/* ParseCoding(): Returns the Coding with the given name, as returned by String(). */
+func ParseCoding(name string) (Coding, error) {
+	for _, m := range []Coding{CodingStatic, CodingAdaptive} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("huffmyfile: unknown coding mode %q", name)
+}
+
+/* adaptiveNode: A node of an adaptive Huffman tree. */
+type adaptiveNode struct {
+	weight      int
+	symbol      int
+	index       int // Position in adaptiveCoder.nodes, lower for higher numbered nodes
+	parent      *adaptiveNode
+	left, right *adaptiveNode // Both nil for leaves
+}
+
+/* adaptiveCoder: The state shared by an adaptive encoder and decoder. */
+type adaptiveCoder struct {
+	alphabet Alphabet
+	nodes    []*adaptiveNode       // Ordered from the root down, by decreasing number
+	leaves   map[int]*adaptiveNode // Leaf of each symbol seen so far
+	nyt      *adaptiveNode
+	path     []uint8 // Reused buffer for encoding
+}
+
+/* newAdaptiveCoder(): Returns a coder whose tree holds only the NYT leaf. */
+func newAdaptiveCoder(alphabet Alphabet) *adaptiveCoder {
+	nyt := &adaptiveNode{}
+	return &adaptiveCoder{
+		alphabet: alphabet,
+		nodes:    []*adaptiveNode{nyt},
+		leaves:   make(map[int]*adaptiveNode),
+		nyt:      nyt,
+	}
+}
+
+/* rawEOF(): Returns the value the end of the block is sent as when it first occurs,
+* which is one past the largest symbol.
+ */
+func (c *adaptiveCoder) rawEOF() uint64 {
+	if c.alphabet == AlphabetBytes {
+		return 0x100
+	}
+	return 0x110000
+}
+
+/* writeNewSymbol(): Writes the value of a symbol that has not occurred before. */
+func (c *adaptiveCoder) writeNewSymbol(bw *BitWriter, symbol int) {
+	raw := uint64(symbol)
+	if symbol == pseudoEOF {
+		raw = c.rawEOF()
+	}
+	switch {
+	case c.alphabet == AlphabetBytes:
+		bw.WriteBits(raw, 9)
+	case raw < 0x80:
+		bw.WriteBits(raw, 8)
+	default:
+		bw.WriteBits(1<<21|raw, 22)
+	}
+}
+
+/* readNewSymbol(): Reads a symbol written by writeNewSymbol(). */
+func (c *adaptiveCoder) readNewSymbol(br *BitReader) (int, error) {
+	n := uint8(9)
+	if c.alphabet != AlphabetBytes {
+		n = 8
+		if bits, _ := br.peekBits(1); bits == 1 {
+			n = 22
+		}
+	}
+	raw, available := br.peekBits(n)
+	if available < n {
+		return 0, truncated(br.err)
+	}
+	br.consume(n)
+	if n == 22 {
+		raw &^= 1 << 21
+	}
+
+	symbol := int(raw)
+	if raw == c.rawEOF() {
+		symbol = pseudoEOF
+	}
+	if _, seen := c.leaves[symbol]; seen || !c.alphabet.validSymbol(symbol) {
+		return 0, fmt.Errorf("%w: invalid new symbol %d", ErrCorruptInput, raw)
+	}
+	return symbol, nil
+}
+
+/* encode(): Writes the current code for symbol, then updates the tree. */
+func (c *adaptiveCoder) encode(bw *BitWriter, symbol int) {
+	leaf, ok := c.leaves[symbol]
+	if !ok {
+		leaf = c.nyt
+	}
+
+	//	The path is found from the leaf up, so it is written out in reverse
+	c.path = c.path[:0]
+	for n := leaf; n.parent != nil; n = n.parent {
+		var bit uint8
+		if n == n.parent.right {
+			bit = 1
+		}
+		c.path = append(c.path, bit)
+	}
+	var bits uint64
+	var length uint8
+	for i := len(c.path) - 1; i >= 0; i-- {
+		bits = bits<<1 | uint64(c.path[i])
+		length++
+		if length == 64 {
+			bw.WriteBits(bits, length)
+			bits, length = 0, 0
+		}
+	}
+	bw.WriteBits(bits, length)
+
+	if !ok {
+		c.writeNewSymbol(bw, symbol)
+	}
+	c.update(symbol)
+}
+
+/* decode(): Reads the next symbol, then updates the tree. */
+func (c *adaptiveCoder) decode(br *BitReader) (int, error) {
+	n := c.nodes[0]
+	for n.left != nil {
+		bit, err := br.readBit()
+		if err != nil {
+			return 0, truncated(err)
+		}
+		if bit == 1 {
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+
+	symbol := n.symbol
+	if n == c.nyt {
+		var err error
+		if symbol, err = c.readNewSymbol(br); err != nil {
+			return 0, err
+		}
+	}
+	c.update(symbol)
+	return symbol, nil
+}
+
+/* update(): Adds one to the weight of symbol's leaf, giving it one first if the symbol
+* is new, and restores the sibling property.
+ */
+func (c *adaptiveCoder) update(symbol int) {
+	leaf, ok := c.leaves[symbol]
+	if !ok {
+		//	The NYT leaf becomes the parent of the new symbol's leaf and a new NYT leaf,
+		//	which take the two lowest numbers
+		parent := c.nyt
+		leaf = &adaptiveNode{symbol: symbol, parent: parent, index: len(c.nodes)}
+		nyt := &adaptiveNode{parent: parent, index: len(c.nodes) + 1}
+		parent.left, parent.right = nyt, leaf
+		c.nodes = append(c.nodes, leaf, nyt)
+		c.leaves[symbol] = leaf
+		c.nyt = nyt
+	}
+
+	for n := leaf; n != nil; n = n.parent {
+		//	The highest numbered node in the block of nodes with the same weight
+		leader := n.index
+		for leader > 0 && c.nodes[leader-1].weight == n.weight {
+			leader--
+		}
+		if other := c.nodes[leader]; other != n && other != n.parent {
+			c.swap(n, other)
+		}
+		n.weight++
+	}
+}
+
+/* swap(): Exchanges the positions of two nodes, along with their subtrees, in both the
+* tree and the ordering. Neither may be an ancestor of the other.
+ */
+func (c *adaptiveCoder) swap(a, b *adaptiveNode) {
+	c.nodes[a.index], c.nodes[b.index] = b, a
+	a.index, b.index = b.index, a.index
+
+	aSlot, bSlot := &a.parent.left, &b.parent.left
+	if a.parent.right == a {
+		aSlot = &a.parent.right
+	}
+	if b.parent.right == b {
+		bSlot = &b.parent.right
+	}
+	*aSlot, *bSlot = b, a
+	a.parent, b.parent = b.parent, a.parent
+}
@@ -0,0 +1,123 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Alphabets decide what each symbol in a code table stands for. Text compresses best
+* coded one character at a time, while binary data has to be coded a byte at a time.
+ */
+
+package huffmyfile
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+/* Alphabet: Selects what each symbol coded by a Writer represents. */
+type Alphabet uint8
+
+const (
+	AlphabetAuto  Alphabet = iota // Runes if the input is valid UTF-8, bytes otherwise
+	AlphabetRunes                 // Unicode code points of UTF-8 encoded text
+	AlphabetBytes                 // Single bytes of arbitrary data
+)
+
+//	Bytes that are not part of valid UTF-8 are coded as runes in the surrogate range,
+//	which never result from decoding UTF-8, so that text with a few stray bytes still
+//	round-trips exactly in rune mode. Only bytes 0x80-0xFF can be invalid.
+const invalidByteBase = 0xDC00
+
+func (a Alphabet) String() string {
+	switch a {
+	case AlphabetAuto:
+		return "auto"
+	case AlphabetRunes:
+		return "runes"
+	case AlphabetBytes:
+		return "bytes"
+	}
+	return fmt.Sprintf("Alphabet(%d)", uint8(a))
+}
+
+/* ParseAlphabet(): Returns the Alphabet with the given name, as returned by String(). */
+func ParseAlphabet(name string) (Alphabet, error) {
+	for _, a := range []Alphabet{AlphabetAuto, AlphabetRunes, AlphabetBytes} {
+		if a.String() == name {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("huffmyfile: unknown alphabet %q", name)
+}
+
+/* chooseAlphabet(): Resolves AlphabetAuto to the alphabet suited to data. */
+func chooseAlphabet(a Alphabet, data []byte) Alphabet {
+	if a != AlphabetAuto {
+		return a
+	}
+	if utf8.Valid(data) {
+		return AlphabetRunes
+	}
+	return AlphabetBytes
+}
+
+/* nextSymbol(): Returns the first symbol of data and the number of bytes it takes up. */
+func (a Alphabet) nextSymbol(data []byte) (symbol int, size int) {
+	if a == AlphabetBytes || data[0] < utf8.RuneSelf {
+		return int(data[0]), 1
+	}
+	r, size := utf8.DecodeRune(data)
+	if r == utf8.RuneError && size == 1 {
+		return invalidByteBase + int(data[0]), 1
+	}
+	return int(r), size
+}
+
+/* putSymbol(): Writes the bytes symbol stands for to p, which must have room for at
+* least utf8.UTFMax bytes, and returns how many were written.
+ */
+func (a Alphabet) putSymbol(p []byte, symbol int) int {
+	if a == AlphabetBytes || symbol < utf8.RuneSelf {
+		p[0] = byte(symbol)
+		return 1
+	}
+	if symbol >= invalidByteBase+0x80 && symbol <= invalidByteBase+0xFF {
+		p[0] = byte(symbol - invalidByteBase)
+		return 1
+	}
+	return utf8.EncodeRune(p, rune(symbol))
+}
+
+/* validSymbol(): Reports whether symbol can appear in a code table for the alphabet. */
+func (a Alphabet) validSymbol(symbol int) bool {
+	if symbol == pseudoEOF {
+		return true
+	}
+	if a == AlphabetBytes {
+		return symbol >= 0 && symbol <= 0xFF
+	}
+	if symbol >= invalidByteBase+0x80 && symbol <= invalidByteBase+0xFF {
+		return true
+	}
+	return symbol >= 0 && utf8.ValidRune(rune(symbol))
+}
+
+/* quote(): Returns symbol as a quoted character with escapes for unprintable ones, the
+* same way HuffTree.Print() shows '\n'.
+ */
+func (a Alphabet) quote(symbol int) string {
+	switch {
+	case symbol == pseudoEOF:
+		return "EOF"
+	case a == AlphabetBytes && symbol >= utf8.RuneSelf:
+		return fmt.Sprintf("'\\x%02x'", symbol)
+	case a != AlphabetBytes && symbol >= invalidByteBase+0x80 && symbol <= invalidByteBase+0xFF:
+		return fmt.Sprintf("'\\x%02x'", symbol-invalidByteBase)
+	}
+	return strconv.QuoteRune(rune(symbol))
+}
@@ -0,0 +1,400 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* .huffa archives bundle many files, each compressed as a separate .huff stream with
+* its own code tables. A central directory at the end records where each entry
+* starts, so any entry can be extracted without decoding the ones before it.
+*
+*	magic           4 bytes  "HUFA"
+*	version         1 byte   archiveVersion
+*	entries                  one .huff stream per entry, see header.go
+*	directory                number of entries as a varint, then for each entry:
+*	                           name length varint, name (slash-separated, relative)
+*	                           mode varint, modification time varint (see header.go)
+*	                           size varint, offset varint, compressed size varint
+*	                           CRC-32C of its contents, 4 bytes
+*	directory offset 8 bytes big-endian offset of the directory from the start
+*	magic           4 bytes  "HUFA"
+ */
+
+package huffmyfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	archiveMagic   = "HUFA"
+	archiveVersion = 1
+
+	// Size of the fixed part at the end of an archive
+	archiveFooterSize = 8 + len(archiveMagic)
+)
+
+/* ArchiveEntry: A file stored in a .huffa archive. */
+type ArchiveEntry struct {
+	Name    string      // Slash-separated path relative to the root of the archive
+	Mode    fs.FileMode // Permission bits
+	ModTime time.Time   // Modification time, zero if it was not recorded
+
+	//	Set by the ArchiveWriter
+	Size           int64  // Size of the contents in bytes
+	CompressedSize int64  // Size of the entry's .huff stream in bytes
+	Checksum       uint32 // CRC-32C of the contents
+
+	offset int64
+}
+
+/* validEntryName(): Reports whether name is a clean relative path that stays inside
+* the directory an archive is extracted to.
+ */
+func validEntryName(name string) bool {
+	return name != "" && path.Clean(name) == name && !path.IsAbs(name) &&
+		name != ".." && !strings.HasPrefix(name, "../") && !strings.ContainsAny(name, "\\\x00")
+}
+
+/* ArchiveWriter: Writes a .huffa archive to an underlying io.Writer, one entry at a
+* time. Close() must be called to write the central directory.
+ */
+type ArchiveWriter struct {
+	Options // Used for every entry, must be set before the first call to Create()
+
+	w       *countingWriter
+	entries []ArchiveEntry
+	current *Writer // Writer compressing the current entry, nil if there is none
+	closed  bool
+	err     error
+}
+
+/* countingWriter: Counts the bytes written to an underlying writer, to find the
+* offset of each entry.
+ */
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+/* NewArchiveWriter(): Returns a new ArchiveWriter writing to w. */
+func NewArchiveWriter(w io.Writer) *ArchiveWriter {
+	return &ArchiveWriter{w: &countingWriter{w: w}}
+}
+
+/* Create(): Adds an entry to the archive and returns a writer its contents should be
+* written to, until the next call to Create() or Close(). Only the Name, Mode and
+* ModTime of entry are used.
+ */
+func (a *ArchiveWriter) Create(entry ArchiveEntry) (io.Writer, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	if a.closed {
+		return nil, errors.New("huffmyfile: create in closed ArchiveWriter")
+	}
+	if !validEntryName(entry.Name) {
+		return nil, fmt.Errorf("huffmyfile: invalid archive entry name %q", entry.Name)
+	}
+	if len(entry.Name) > maxNameLength {
+		return nil, fmt.Errorf("huffmyfile: archive entry name is longer than %d bytes", maxNameLength)
+	}
+	if a.err = a.finishEntry(); a.err != nil {
+		return nil, a.err
+	}
+
+	a.entries = append(a.entries, ArchiveEntry{
+		Name:    entry.Name,
+		Mode:    entry.Mode & fs.ModePerm,
+		ModTime: entry.ModTime,
+		offset:  a.w.n,
+	})
+	a.current = NewWriter(a.w)
+	a.current.Options = a.Options
+	return a.current, nil
+}
+
+/* AddFile(): Adds the contents, permissions and modification time of the named file
+* to the archive as an entry called name.
+ */
+func (a *ArchiveWriter) AddFile(fileName, name string) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := a.Create(ArchiveEntry{Name: name, Mode: info.Mode(), ModTime: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+/* finishEntry(): Ends the current entry, if any, and records its sizes. Writes the
+* start of the archive first if nothing has been written yet.
+ */
+func (a *ArchiveWriter) finishEntry() error {
+	if a.w.n == 0 {
+		if _, err := io.WriteString(a.w, archiveMagic+string(rune(archiveVersion))); err != nil {
+			return err
+		}
+	}
+	if a.current == nil {
+		return nil
+	}
+	if err := a.current.Close(); err != nil {
+		return err
+	}
+	entry := &a.entries[len(a.entries)-1]
+	entry.Size = a.current.size
+	entry.Checksum = a.current.crc
+	entry.CompressedSize = a.w.n - entry.offset
+	a.current = nil
+	return nil
+}
+
+/* Close(): Ends the last entry and writes the central directory. Close does not close
+* the underlying writer.
+ */
+func (a *ArchiveWriter) Close() error {
+	if a.err != nil {
+		return a.err
+	}
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if a.err = a.finishEntry(); a.err != nil {
+		return a.err
+	}
+
+	directoryOffset := a.w.n
+	w := bufio.NewWriter(a.w)
+	writeUvarint(w, uint64(len(a.entries)))
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, entry := range a.entries {
+		writeUvarint(w, uint64(len(entry.Name)))
+		w.WriteString(entry.Name)
+		writeUvarint(w, uint64(entry.Mode))
+		var modTime int64
+		if !entry.ModTime.IsZero() {
+			modTime = entry.ModTime.UnixNano()
+		}
+		w.Write(buf[:binary.PutVarint(buf, modTime)])
+		writeUvarint(w, uint64(entry.Size))
+		writeUvarint(w, uint64(entry.offset))
+		writeUvarint(w, uint64(entry.CompressedSize))
+		writeChecksum(w, entry.Checksum)
+	}
+
+	binary.BigEndian.PutUint64(buf[:8], uint64(directoryOffset))
+	w.Write(buf[:8])
+	w.WriteString(archiveMagic)
+	a.err = w.Flush()
+	return a.err
+}
+
+/* ArchiveReader: Reads the entries of a .huffa archive. */
+type ArchiveReader struct {
+	Entries []ArchiveEntry // In the order they were written
+
+	//	Dictionary the entries were compressed with, if any
+	Dictionary *Dictionary
+
+	r io.ReaderAt
+}
+
+/* NewArchiveReader(): Reads the central directory of the archive of the given size
+* read from r.
+ */
+func NewArchiveReader(r io.ReaderAt, size int64) (*ArchiveReader, error) {
+	start := make([]byte, len(archiveMagic)+1)
+	footer := make([]byte, archiveFooterSize)
+	if size < int64(len(start)+len(footer)) {
+		return nil, ErrNotArchive
+	}
+	if _, err := r.ReadAt(start, 0); err != nil {
+		return nil, err
+	}
+	if string(start[:len(archiveMagic)]) != archiveMagic {
+		return nil, ErrNotArchive
+	}
+	if start[len(archiveMagic)] != archiveVersion {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, start[len(archiveMagic)])
+	}
+	if _, err := r.ReadAt(footer, size-int64(len(footer))); err != nil {
+		return nil, err
+	}
+	if string(footer[8:]) != archiveMagic {
+		return nil, fmt.Errorf("%w: archive is truncated", ErrCorruptInput)
+	}
+	directoryOffset := int64(binary.BigEndian.Uint64(footer))
+	directoryEnd := size - int64(len(footer))
+	if directoryOffset < int64(len(start)) || directoryOffset > directoryEnd {
+		return nil, fmt.Errorf("%w: invalid archive directory offset", ErrCorruptInput)
+	}
+
+	directory := make([]byte, directoryEnd-directoryOffset)
+	if _, err := r.ReadAt(directory, directoryOffset); err != nil {
+		return nil, err
+	}
+	entries, err := readArchiveDirectory(bytes.NewReader(directory), directoryOffset)
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveReader{Entries: entries, r: r}, nil
+}
+
+/* readArchiveDirectory(): Reads the entries in a central directory, checking that they
+* all lie before directoryOffset.
+ */
+func readArchiveDirectory(r *bytes.Reader, directoryOffset int64) ([]ArchiveEntry, error) {
+	count, err := readUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	//	Every entry takes up at least a few bytes, which bounds a sensible count
+	if count > uint64(r.Len()) {
+		return nil, fmt.Errorf("%w: invalid archive entry count %d", ErrCorruptInput, count)
+	}
+
+	entries := make([]ArchiveEntry, 0, count)
+	for i := uint64(0); i < count; i++ {
+		var entry ArchiveEntry
+		n, err := readUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxNameLength || n > uint64(r.Len()) {
+			return nil, fmt.Errorf("%w: invalid archive entry name length %d", ErrCorruptInput, n)
+		}
+		name := make([]byte, n)
+		r.Read(name)
+		entry.Name = string(name)
+		if !validEntryName(entry.Name) {
+			return nil, fmt.Errorf("%w: invalid archive entry name %q", ErrCorruptInput, entry.Name)
+		}
+
+		mode, err := readUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if mode&^uint64(fs.ModePerm) != 0 {
+			return nil, fmt.Errorf("%w: invalid file mode %#o", ErrCorruptInput, mode)
+		}
+		entry.Mode = fs.FileMode(mode)
+		modTime, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, truncated(err)
+		}
+		if modTime != 0 {
+			entry.ModTime = time.Unix(0, modTime)
+		}
+
+		var sizes [3]uint64
+		for j := range sizes {
+			if sizes[j], err = readUvarint(r); err != nil {
+				return nil, err
+			}
+		}
+		entry.Size, entry.offset, entry.CompressedSize = int64(sizes[0]), int64(sizes[1]), int64(sizes[2])
+		if sizes[0] > 1<<62 || sizes[1] > uint64(directoryOffset) || sizes[2] > uint64(directoryOffset)-sizes[1] {
+			return nil, fmt.Errorf("%w: archive entry %q lies outside the archive", ErrCorruptInput, entry.Name)
+		}
+		if entry.Checksum, err = readChecksum(r); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+/* Open(): Returns a reader decompressing the contents of entry, which must be one of
+* the archive's Entries. Its size and checksum are checked against the directory
+* once the end is reached.
+ */
+func (a *ArchiveReader) Open(entry ArchiveEntry) (io.Reader, error) {
+	zr, err := NewReader(io.NewSectionReader(a.r, entry.offset, entry.CompressedSize))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", entry.Name, err)
+	}
+	zr.Dictionary = a.Dictionary
+	return &entryReader{r: zr, entry: entry, crc: crc32.New(crcTable)}, nil
+}
+
+/* Extract(): Decompresses entry to the named file, restoring its permissions and
+* modification time. The file only appears once the entry has been decoded in full
+* and checked, and an existing file is only replaced if overwrite is set.
+ */
+func (a *ArchiveReader) Extract(entry ArchiveEntry, name string, overwrite bool) error {
+	r, err := a.Open(entry)
+	if err != nil {
+		return err
+	}
+	f, err := CreateOutputFile(name, overwrite)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+
+	w := bufio.NewWriter(f)
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	f.restore = &Header{Mode: entry.Mode, ModTime: entry.ModTime}
+	return f.Commit()
+}
+
+/* entryReader: Checks the contents of an archive entry against the directory. */
+type entryReader struct {
+	r     io.Reader
+	entry ArchiveEntry
+	crc   hash.Hash32
+	n     int64
+}
+
+func (er *entryReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	er.crc.Write(p[:n])
+	er.n += int64(n)
+	if err == io.EOF {
+		if er.n != er.entry.Size {
+			return n, fmt.Errorf("%s: %w: decoded %d bytes, expected %d", er.entry.Name, ErrCorruptInput, er.n, er.entry.Size)
+		}
+		if er.crc.Sum32() != er.entry.Checksum {
+			return n, fmt.Errorf("%s: %w: contents do not match the archive directory", er.entry.Name, ErrChecksum)
+		}
+	} else if err != nil {
+		err = fmt.Errorf("%s: %w", er.entry.Name, err)
+	}
+	return n, err
+}
@@ -9,14 +9,20 @@ https://opensource.org/licenses/MIT.
 package huffmyfile
 
 import (
+	"encoding/binary"
 	"errors"
 	"io"
 )
 
+// Size of the chunks read from the underlying reader
+const bitReaderChunkSize = 4096
+
 type BitReader struct {
 	reader   io.Reader // Underlying reader
-	buffer   byte      // Buffer to read individual bits from
-	bitCount uint8     // Keeping track of what bits have been read
+	data     []byte    // Storage for chunks read from the reader
+	chunk    []byte    // Bytes read from the reader, not yet moved into the buffer
+	buffer   uint64    // Bits read ahead from the reader, starting at the most significant bit
+	bitCount uint8     // Number of bits in the buffer
 	err      error
 }
 
@@ -33,10 +39,6 @@ func NewBitReader(reader io.Reader) *BitReader {
 *	ReadBit(): Wrapper function for readBit() to return a bool rather than uint8
  */
 func (br *BitReader) ReadBit() (bit bool, err error) {
-	if br.err != nil {
-		return false, br.err
-	}
-
 	b, err := br.readBit()
 	if err != nil {
 		return false, err
@@ -54,47 +56,120 @@ func (br *BitReader) ReadBit() (bit bool, err error) {
 *	bitCount reaches 0.
  */
 func (br *BitReader) readBit() (bit uint8, err error) {
-	if br.err != nil {
-		return 0, br.err
-	}
-
 	if br.bitCount == 0 {
-		br.buffer, err = br.ReadByte()
+		b, err := br.readByte()
 		if err != nil {
 			return 0, err
 		}
+		br.buffer = uint64(b) << 56
 		br.bitCount = 8
 	}
 
-	bit = (br.buffer >> (br.bitCount - 1)) & 1
-	br.bitCount--
+	bit = uint8(br.buffer >> 63)
+	br.consume(1)
 	return bit, nil
 }
 
-/*	ReadByte(): Wrapper function for readByte(). Throws an error if ReadByte() is called
-*	while the buffer is still full.
+/*	peekBits(): Returns the next n bits (at most 56) in the low bits of the result
+*	without consuming them, along with how many of them are available. Bits past the
+*	end of the input read as 0.
  */
-func (br *BitReader) ReadByte() (b byte, err error) {
-	if br.err != nil {
-		return 0, br.err
+func (br *BitReader) peekBits(n uint8) (bits uint64, available uint8) {
+	if br.bitCount < n {
+		//	Fill the buffer as far as possible, so most peeks don't need to read. Whole
+		//	bytes are moved in at once when enough of them have been read.
+		if len(br.chunk) >= 8 {
+			fill := (63 - br.bitCount) / 8
+			br.buffer |= binary.BigEndian.Uint64(br.chunk) >> br.bitCount &^ (1<<(64-br.bitCount-fill*8) - 1)
+			br.bitCount += fill * 8
+			br.chunk = br.chunk[fill:]
+		}
+		for br.bitCount <= 56 && br.err == nil {
+			b, err := br.readByte()
+			if err != nil {
+				break
+			}
+			br.buffer |= uint64(b) << (56 - br.bitCount)
+			br.bitCount += 8
+		}
 	}
+	return br.buffer >> (64 - n), br.bitCount
+}
 
-	if br.bitCount > 0 {
+/*	consume(): Discards the next n bits, which must already be in the buffer. */
+func (br *BitReader) consume(n uint8) {
+	br.buffer <<= n
+	br.bitCount -= n
+}
+
+/*	Align(): Discards any bits left over from the current byte, so that the next read
+*	starts on a byte boundary.
+ */
+func (br *BitReader) Align() {
+	br.consume(br.bitCount % 8)
+}
+
+/*	ReadByte(): Reads the next 8 bits as a byte. Throws an error if the reader is not
+*	on a byte boundary.
+ */
+func (br *BitReader) ReadByte() (b byte, err error) {
+	if br.bitCount%8 != 0 {
 		return 0, errors.New("cannot read new byte with bits remaining in buffer")
 	}
 
+	if br.bitCount > 0 {
+		b = byte(br.buffer >> 56)
+		br.consume(8)
+		return b, nil
+	}
 	return br.readByte()
 }
 
-/*	readByte(): Uses the underlying reader to read a single byte into a buffer, then
-*	returns that byte.
+/*	Read(): Reads whole bytes into p, so the reader must be on a byte boundary. Bytes
+*	already read ahead are returned first.
+ */
+func (br *BitReader) Read(p []byte) (n int, err error) {
+	for n < len(p) && br.bitCount > 0 {
+		if p[n], err = br.ReadByte(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if len(br.chunk) > 0 {
+		c := copy(p[n:], br.chunk)
+		br.chunk = br.chunk[c:]
+		n += c
+	}
+	if n < len(p) && br.err == nil {
+		var c int
+		c, br.err = br.reader.Read(p[n:])
+		n += c
+	}
+	if n == 0 && br.err != nil {
+		return 0, br.err
+	}
+	return n, nil
+}
+
+/*	readByte(): Returns the next byte read from the underlying reader, reading a new
+*	chunk when the last one has been used up.
  */
 func (br *BitReader) readByte() (b byte, err error) {
-	buff := make([]byte, 1)
-	_, err = br.reader.Read(buff)
-	if err != nil {
-		br.err = err
-		return 0, err
+	for len(br.chunk) == 0 {
+		if br.err != nil {
+			return 0, br.err
+		}
+		if br.data == nil {
+			br.data = make([]byte, bitReaderChunkSize)
+		}
+		n, err := br.reader.Read(br.data)
+		br.chunk = br.data[:n]
+		if err != nil {
+			br.err = err
+		}
 	}
-	return buff[0], nil
+
+	b = br.chunk[0]
+	br.chunk = br.chunk[1:]
+	return b, nil
 }
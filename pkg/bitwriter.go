@@ -36,30 +36,60 @@ func (bw *BitWriter) WriteBit(bit bool) error {
 
 	if bw.offset == 8 {
 		// Write the accumulated byte to the underlying writer
-		_, err := bw.writer.Write([]byte{bw.buffer})
-		if err != nil {
-			return err
+		return bw.writeBuffer()
+	}
+
+	return nil
+}
+
+/* WriteBits(): Writes the lowest n bits of bits, most significant bit first. Fills the
+*	buffer byte as many bits at a time as will fit, rather than one at a time.
+ */
+func (bw *BitWriter) WriteBits(bits uint64, n uint8) error {
+	for n > 0 {
+		free := 8 - bw.offset
+		take := n
+		if take > free {
+			take = free
 		}
+		n -= take
+		chunk := byte(bits>>n) & (1<<take - 1)
+		bw.buffer |= chunk << (free - take)
+		bw.offset += take
 
-		bw.buffer = 0
-		bw.offset = 0
+		if bw.offset == 8 {
+			if err := bw.writeBuffer(); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
 }
 
+/* writeBuffer(): Writes the buffer byte to the underlying writer and empties it. */
+func (bw *BitWriter) writeBuffer() error {
+	var err error
+	if byteWriter, ok := bw.writer.(io.ByteWriter); ok {
+		err = byteWriter.WriteByte(bw.buffer)
+	} else {
+		_, err = bw.writer.Write([]byte{bw.buffer})
+	}
+	if err != nil {
+		return err
+	}
+
+	bw.buffer = 0
+	bw.offset = 0
+	return nil
+}
+
 /* Flush(): Writes whatever bits are left to the underlying writer.
  */
 func (bw *BitWriter) Flush() error {
 	if bw.offset > 0 {
 		// Write the remaining bits to the underlying writer
-		_, err := bw.writer.Write([]byte{bw.buffer})
-		if err != nil {
-			return err
-		}
-
-		bw.buffer = 0
-		bw.offset = 0
+		return bw.writeBuffer()
 	}
 
 	return nil
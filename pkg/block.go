@@ -0,0 +1,385 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Input is compressed in blocks, each with its own code table, so it can be read in
+* a single pass without knowing the frequencies of the whole input up front, memory
+* use is bounded by the block size, and each block's code suits its own part of the
+* input. After the header, a .huff file is a sequence of blocks ended by an empty
+* block and a checksum of the whole input:
+*
+*	block size      varint   number of bytes of input in the block, 0 for the end
+*	encoded size    varint   number of bytes of code table, body and checksum that follow
+*	table kind      1 byte   tableOwn or tableFromDictionary, only if the header has a
+*	                         dictionary ID
+*	code table               see writeCodeLengths(), left out if the dictionary's is used
+*	                         or if flagAdaptive is set, replaced by two tables if
+*	                         flagLZ77 is set, see lz77.go, and preceded by a primary
+*	                         index if the table encoding is tableBWT, see bwt.go, or
+*	                         replaced by a table per group of contexts if it is
+*	                         tableContext, see context.go
+*	body                     encoded symbols followed by a pseudo-EOF, padded to a byte
+*	checksum        4 bytes  CRC-32C of the block's input, only if flagBlockChecksums is set
+*	...
+*	end             varint   0
+*	checksum        4 bytes  CRC-32C of the whole input
+*
+* Since every block records its encoded size, a block that fails to decode can be
+* skipped and the blocks after it decoded as normal. Checksums are big-endian.
+ */
+
+package huffmyfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"unicode/utf8"
+)
+
+// Block sizes
+const (
+	DefaultBlockSize = 1 << 20 // Block size used when Options.BlockSize is 0
+	MaxBlockSize     = 1 << 30 // Largest block size that can be written or read
+)
+
+// Table for the CRC-32C checksums stored in the stream
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Size of a stored checksum
+const checksumSize = 4
+
+// Where a block's code table comes from, in streams that use a dictionary
+const (
+	tableOwn            = 0 // The block stores its own code table
+	tableFromDictionary = 1 // The block is coded with the dictionary's code table
+)
+
+// byteWriter is implemented by both bufio.Writer and bytes.Buffer
+type byteWriter interface {
+	io.Writer
+	io.ByteWriter
+}
+
+/* runeBoundary(): Returns the length of the longest prefix of data that does not end
+* in the middle of a UTF-8 sequence, so that runes are not split between blocks.
+ */
+func runeBoundary(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(data)
+}
+
+/* encodeBlock(): Builds the Huffman code for data and returns the encoded block, made
+* up of the code table followed by the body, and the checksum of data if the header
+* asks for block checksums. If the header has a dictionary ID, the block is coded with
+* dict's code table instead whenever that takes fewer bits than storing its own. For
+* context blocks, order0Size is the size the block would have taken with a single code
+* table, for Writer.ContextGain(), and it is 0 otherwise.
+ */
+func encodeBlock(data []byte, h *Header, dict *Dictionary) (encoded []byte, order0Size int, err error) {
+	var crc uint32
+	if h.BlockChecksums {
+		crc = crc32.Checksum(data, crcTable)
+	}
+
+	switch {
+	case h.Adaptive:
+		return encodeAdaptiveBlock(data, h, crc), 0, nil
+	case h.Level != 0:
+		encoded, err = encodeLZ77Block(data, h, crc)
+		return encoded, 0, err
+	case h.BWT:
+		encoded, err = encodeBWTBlock(data, h, crc)
+		return encoded, 0, err
+	case h.Contexts:
+		return encodeContextBlock(data, h, crc)
+	}
+
+	frequencyMap := countSymbols(data, h.Alphabet)
+
+	//Only the code lengths are taken from the Huffman tree, the codes themselves are
+	//the canonical codes for those lengths.
+	limit := h.MaxCodeLength
+	if limit == 0 {
+		limit = maxCodeLength
+	}
+	huffmanTree := HuffTree{}
+	if err := huffmanTree.MakeLengthLimitedTree(frequencyMap, limit); err != nil {
+		return nil, 0, err
+	}
+	lengthMap := huffmanTree.CodeLengths()
+	codeMap := canonicalCodes(lengthMap)
+
+	var buf, table bytes.Buffer
+	buf.Grow(len(data) / 2)
+	writeCodeLengths(&table, lengthMap)
+	if h.DictionaryID != 0 {
+		if dict.covers(frequencyMap) && dict.maxLength <= limit &&
+			codedBits(frequencyMap, dict.lengthMap) <= codedBits(frequencyMap, lengthMap)+table.Len()*8 {
+			buf.WriteByte(tableFromDictionary)
+			codeMap = dict.codeMap
+			table.Reset()
+		} else {
+			buf.WriteByte(tableOwn)
+		}
+	}
+	buf.Write(table.Bytes())
+
+	//Write encoded body followed by the pseudo-EOF
+	bitWriter := NewBitWriter(&buf)
+	for len(data) > 0 {
+		c, size := h.Alphabet.nextSymbol(data)
+		data = data[size:]
+		code := codeMap[c]
+		bitWriter.WriteBits(code.bits, code.length)
+	}
+	code := codeMap[pseudoEOF]
+	bitWriter.WriteBits(code.bits, code.length)
+	bitWriter.Flush()
+
+	if h.BlockChecksums {
+		writeChecksum(&buf, crc)
+	}
+	return buf.Bytes(), 0, nil
+}
+
+/* encodeAdaptiveBlock(): Encodes a block with adaptive codes, followed by its checksum
+* if the header asks for block checksums.
+ */
+func encodeAdaptiveBlock(data []byte, h *Header, crc uint32) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(data) / 2)
+	coder := newAdaptiveCoder(h.Alphabet)
+	bitWriter := NewBitWriter(&buf)
+	for len(data) > 0 {
+		c, size := h.Alphabet.nextSymbol(data)
+		data = data[size:]
+		coder.encode(bitWriter, c)
+	}
+	coder.encode(bitWriter, pseudoEOF)
+	bitWriter.Flush()
+
+	if h.BlockChecksums {
+		writeChecksum(&buf, crc)
+	}
+	return buf.Bytes()
+}
+
+/* codedBits(): Returns the number of bits the symbols in frequencyMap take with codes
+* of the given lengths.
+ */
+func codedBits(frequencyMap, lengthMap map[int]int) int {
+	n := 0
+	for k, f := range frequencyMap {
+		n += f * lengthMap[k]
+	}
+	return n
+}
+
+/* writeBlock(): Writes an encoded block along with the sizes that come before it. */
+func writeBlock(w byteWriter, size int, encoded []byte) error {
+	writeUvarint(w, uint64(size))
+	writeUvarint(w, uint64(len(encoded)))
+	_, err := w.Write(encoded)
+	return err
+}
+
+/* writeEndBlock(): Writes the empty block marking the end of the blocks, followed by
+* the checksum of the whole input.
+ */
+func writeEndBlock(w byteWriter, crc uint32) error {
+	writeUvarint(w, 0)
+	return writeChecksum(w, crc)
+}
+
+func writeChecksum(w io.Writer, crc uint32) error {
+	var buf [checksumSize]byte
+	binary.BigEndian.PutUint32(buf[:], crc)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func readChecksum(r io.Reader) (uint32, error) {
+	var buf [checksumSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, truncated(err)
+	}
+	return binary.BigEndian.Uint32(buf[:]), nil
+}
+
+/* readBlock(): Reads the next block from br without decoding it. Returns a size of 0
+* for the empty block marking the end.
+ */
+func readBlock(br *BitReader) (size int, encoded []byte, err error) {
+	blockSize, err := readUvarint(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	if blockSize == 0 {
+		return 0, nil, nil
+	}
+	if blockSize > MaxBlockSize {
+		return 0, nil, fmt.Errorf("%w: block size %d is too large", ErrCorruptInput, blockSize)
+	}
+	encodedSize, err := readUvarint(br)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	//	The buffer only grows as data arrives, so a corrupt size cannot cause a huge
+	//	allocation
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, br, int64(encodedSize)); err != nil {
+		return 0, nil, truncated(err)
+	}
+	return int(blockSize), buf.Bytes(), nil
+}
+
+/* decodeBlock(): Decodes an encoded block holding size bytes of input, checking its
+* contents against the checksum at the end if the header asks for block checksums.
+ */
+func decodeBlock(encoded []byte, size int, h *Header, dict *Dictionary) ([]byte, error) {
+	var crc uint32
+	if h.BlockChecksums {
+		if len(encoded) < checksumSize {
+			return nil, fmt.Errorf("%w: block is too short to hold a checksum", ErrCorruptInput)
+		}
+		crc = binary.BigEndian.Uint32(encoded[len(encoded)-checksumSize:])
+		encoded = encoded[:len(encoded)-checksumSize]
+	}
+
+	//	Every symbol takes at least one bit, and every match at least two, so a larger
+	//	size must be corrupt. Runs of zeros in BWT blocks can be any length, so those
+	//	are limited to MaxBWTBlockSize instead, see decodeBWTBlock().
+	perBit := utf8.UTFMax
+	if h.Level != 0 {
+		perBit = lzMaxMatch
+	}
+	if !h.BWT && size > len(encoded)*8*perBit {
+		return nil, fmt.Errorf("%w: block size %d is too large for its contents", ErrCorruptInput, size)
+	}
+
+	br := NewBitReader(bytes.NewReader(encoded))
+	var data []byte
+	switch {
+	case h.Level != 0:
+		tables, err := readLZ77Tables(br, h)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = tables.decode(br, size, h, nil); err != nil {
+			return nil, err
+		}
+	case h.BWT:
+		var err error
+		if data, err = decodeBWTBlock(br, size, h, nil); err != nil {
+			return nil, err
+		}
+	case h.Contexts:
+		tables, err := readContextTables(br, h)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = decodeSymbols(br, size, h.Alphabet, tables.decoder(nil)); err != nil {
+			return nil, err
+		}
+	case h.Adaptive:
+		var err error
+		if data, err = decodeSymbols(br, size, h.Alphabet, newAdaptiveCoder(h.Alphabet).decode); err != nil {
+			return nil, err
+		}
+	default:
+		_, table, err := readBlockTable(br, h, dict)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = decodeSymbols(br, size, h.Alphabet, table.decode); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data) != size {
+		return nil, fmt.Errorf("%w: decoded %d bytes, block size is %d", ErrCorruptInput, len(data), size)
+	}
+	if h.BlockChecksums && crc32.Checksum(data, crcTable) != crc {
+		return nil, fmt.Errorf("%w: block contents", ErrChecksum)
+	}
+	return data, nil
+}
+
+/* decodeSymbols(): Decodes symbols with decode until the pseudo-EOF, failing if they
+* come to more than size bytes.
+ */
+func decodeSymbols(br *BitReader, size int, alphabet Alphabet, decode func(br *BitReader) (int, error)) ([]byte, error) {
+	data := make([]byte, 0, size)
+	var buf [utf8.UTFMax]byte
+	for {
+		c, err := decode(br)
+		if err != nil {
+			return nil, err
+		}
+		if c == pseudoEOF {
+			return data, nil
+		}
+		if len(data) >= size {
+			return nil, fmt.Errorf("%w: block is longer than its recorded size", ErrCorruptInput)
+		}
+		data = append(data, buf[:alphabet.putSymbol(buf[:], c)]...)
+	}
+}
+
+/* readBlockTable(): Reads the code table at the start of a block, or finds it in the
+* dictionary, and returns its code lengths and decoding table.
+ */
+func readBlockTable(br *BitReader, h *Header, dict *Dictionary) (map[int]int, *decodeTable, error) {
+	if h.DictionaryID != 0 {
+		kind, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, truncated(err)
+		}
+		switch kind {
+		case tableOwn:
+		case tableFromDictionary:
+			if dict == nil || dict.ID != h.DictionaryID {
+				return nil, nil, fmt.Errorf("%w: the input needs dictionary %08x", ErrDictionary, h.DictionaryID)
+			}
+			if h.MaxCodeLength != 0 && dict.maxLength > h.MaxCodeLength {
+				return nil, nil, fmt.Errorf("%w: dictionary codes are longer than the limit", ErrCorruptInput)
+			}
+			return dict.lengthMap, dict.table, nil
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown code table kind %d", ErrCorruptInput, kind)
+		}
+	}
+
+	lengthMap, err := readCodeLengths(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(lengthMap) == 0 {
+		return nil, nil, ErrEmptyCodeTable
+	}
+	for k, l := range lengthMap {
+		if !h.Alphabet.validSymbol(k) {
+			return nil, nil, fmt.Errorf("%w: invalid symbol %d in code table", ErrCorruptInput, k)
+		}
+		if h.MaxCodeLength != 0 && l > h.MaxCodeLength {
+			return nil, nil, fmt.Errorf("%w: code length %d exceeds the limit of %d", ErrCorruptInput, l, h.MaxCodeLength)
+		}
+	}
+	return lengthMap, newDecodeTable(canonicalCodes(lengthMap)), nil
+}
@@ -0,0 +1,416 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* The bzip2-style pipeline used with MethodBWT. Each block is first put through the
+* Burrows-Wheeler transform, which sorts its bytes by what follows them so that bytes
+* seen in the same context end up next to each other. Move-to-front then turns those
+* runs of similar bytes into runs of small numbers, mostly zeros, and runs of zeros
+* are shortened with zero-run-length coding before the result is Huffman coded.
+*
+* An end of block marker, smaller than any byte, is added before sorting, so the sort
+* is that of the suffixes of the block. The transform is the byte before each suffix in
+* sorted order, leaving out the marker, whose position is stored as the primary index.
+*
+* Move-to-front values 1-255 are coded as symbols 2-256. A run of n zeros is coded as
+* n+1 in binary, least significant bit first and without its leading 1, using RUNA (0)
+* for 0 bits and RUNB (1) for 1 bits. A BWT block replaces the code table of block.go
+* with:
+*
+*	primary index   varint   position of the end of block marker in the transform
+*	code table               see writeCodeLengths()
+ */
+
+package huffmyfile
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Largest block the BWT pipeline works on. A run of zeros codes to a few bits however
+// long it is, so a corrupt BWT block only a few bytes long could otherwise claim up to
+// MaxBlockSize bytes, and the inverse transform takes several times that in memory.
+const MaxBWTBlockSize = 1 << 23
+
+/* Method: Selects how a Writer transforms blocks before Huffman coding them. */
+type Method uint8
+
+const (
+	MethodHuffman Method = iota // Symbols are Huffman coded as they are, or after LZ77 if Options.Level is set
+	MethodBWT                   // Blocks go through the Burrows-Wheeler transform, move-to-front and zero-run-length coding
+	MethodContext               // Each symbol is coded with a table chosen by the symbol before it, see context.go
+)
+
+func (m Method) String() string {
+	switch m {
+	case MethodHuffman:
+		return "huffman"
+	case MethodBWT:
+		return "bwt"
+	case MethodContext:
+		return "context"
+	}
+	return fmt.Sprintf("Method(%d)", uint8(m))
+}
+
+/* ParseMethod(): Returns the Method with the given name, as returned by String(). */
+func ParseMethod(name string) (Method, error) {
+	for _, m := range []Method{MethodHuffman, MethodBWT, MethodContext} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("huffmyfile: unknown method %q", name)
+}
+
+// Symbols coded after move-to-front and zero-run-length coding
+const (
+	bwtRunA    = 0   // A 0 bit of a run of zeros
+	bwtRunB    = 1   // A 1 bit of a run of zeros
+	bwtSymbols = 257 // Number of symbols, not counting the pseudo-EOF
+)
+
+/* BWT(): Returns the Burrows-Wheeler transform of data, which has the same length, and
+* the primary index needed to invert it.
+ */
+func BWT(data []byte) ([]byte, int) {
+	sa := suffixArray(data)
+	transformed := make([]byte, 0, len(data))
+	primary := 0
+	for i, p := range sa {
+		if p == 0 {
+			primary = i
+			continue
+		}
+		transformed = append(transformed, data[p-1])
+	}
+	return transformed, primary
+}
+
+/* suffixArray(): Returns the start of every suffix of data followed by the end of block
+* marker, in sorted order, with the empty suffix first. The suffixes are sorted by
+* prefix doubling: sorted by their first k symbols, each rank is a single number, so
+* sorting by the pair of ranks at i and i+k sorts them by their first 2k symbols.
+* Every pass is a counting sort, so the whole sort takes O(n log n) time.
+ */
+func suffixArray(data []byte) []int32 {
+	n := len(data) + 1
+	sa := make([]int32, n)
+	rank := make([]int32, n)
+	next := make([]int32, n)
+	tmp := make([]int32, n)
+
+	//	Sort by the first symbol, with the marker as 0 and bytes shifted up by one
+	classes := 257
+	if n > classes {
+		classes = n
+	}
+	count := make([]int32, classes)
+	symbol := func(i int) int32 {
+		if i == len(data) {
+			return 0
+		}
+		return int32(data[i]) + 1
+	}
+	for i := 0; i < n; i++ {
+		count[symbol(i)]++
+	}
+	for c := 1; c < 257; c++ {
+		count[c] += count[c-1]
+	}
+	for i := n - 1; i >= 0; i-- {
+		c := symbol(i)
+		count[c]--
+		sa[count[c]] = int32(i)
+	}
+	rank[sa[0]] = 0
+	distinct := int32(1)
+	for i := 1; i < n; i++ {
+		if symbol(int(sa[i])) != symbol(int(sa[i-1])) {
+			distinct++
+		}
+		rank[sa[i]] = distinct - 1
+	}
+
+	//	The marker is unique, so sorting the rotations of data and the marker sorts
+	//	the suffixes, and positions past the end can wrap around
+	for k := 1; int(distinct) < n; k <<= 1 {
+		//	Already sorted by the rank at i+k, so a stable sort by the rank at i
+		//	sorts by the pair
+		for i, p := range sa {
+			tmp[i] = int32((int(p) - k + n) % n)
+		}
+		for c := int32(0); c < distinct; c++ {
+			count[c] = 0
+		}
+		for _, p := range tmp {
+			count[rank[p]]++
+		}
+		for c := int32(1); c < distinct; c++ {
+			count[c] += count[c-1]
+		}
+		for i := n - 1; i >= 0; i-- {
+			p := tmp[i]
+			count[rank[p]]--
+			sa[count[rank[p]]] = p
+		}
+
+		next[sa[0]] = 0
+		distinct = 1
+		for i := 1; i < n; i++ {
+			p, q := int(sa[i]), int(sa[i-1])
+			if rank[p] != rank[q] || rank[(p+k)%n] != rank[(q+k)%n] {
+				distinct++
+			}
+			next[p] = distinct - 1
+		}
+		rank, next = next, rank
+	}
+	return sa
+}
+
+/* InverseBWT(): Returns the data whose Burrows-Wheeler transform and primary index are
+* given.
+ */
+func InverseBWT(transformed []byte, primary int) ([]byte, error) {
+	n := len(transformed) + 1
+	if primary < 0 || primary >= n {
+		return nil, fmt.Errorf("%w: invalid primary index %d", ErrCorruptInput, primary)
+	}
+
+	//	Each row's last symbol is the one before its suffix, so following a row to the
+	//	row starting with its last symbol steps back through the data. The k-th of
+	//	each symbol in the last column is the k-th in the sorted first column.
+	var count [256]int
+	for _, b := range transformed {
+		count[b]++
+	}
+	var first [256]int
+	total := 1 // The marker sorts first
+	for b := range count {
+		first[b] = total
+		total += count[b]
+	}
+	previous := make([]int32, n)
+	for i := 0; i < n; i++ {
+		if i == primary {
+			continue
+		}
+		b := transformed[lastColumnIndex(i, primary)]
+		previous[i] = int32(first[b])
+		first[b]++
+	}
+
+	//	Row 0 is the empty suffix, whose last symbol is the last byte of the data
+	data := make([]byte, n-1)
+	row := 0
+	for i := n - 2; i >= 0; i-- {
+		if row == primary {
+			return nil, fmt.Errorf("%w: primary index does not match the transform", ErrCorruptInput)
+		}
+		data[i] = transformed[lastColumnIndex(row, primary)]
+		row = int(previous[row])
+	}
+	if row != primary {
+		return nil, fmt.Errorf("%w: primary index does not match the transform", ErrCorruptInput)
+	}
+	return data, nil
+}
+
+/* lastColumnIndex(): Returns where the last symbol of a row other than the primary
+* index is stored in the transform, which leaves out the marker.
+ */
+func lastColumnIndex(row, primary int) int {
+	if row > primary {
+		return row - 1
+	}
+	return row
+}
+
+/* moveToFront(): Replaces each byte with the number of distinct bytes seen since its
+* last occurrence, keeping a list of bytes ordered by how recently they were seen.
+* The same function with inverse set undoes it.
+ */
+func moveToFront(data []byte, inverse bool) []byte {
+	var order [256]byte
+	for i := range order {
+		order[i] = byte(i)
+	}
+	result := make([]byte, len(data))
+	for i, v := range data {
+		//	Find the byte and its position in the list, then move it to the front
+		var b byte
+		var j int
+		if inverse {
+			j = int(v)
+			b = order[j]
+			result[i] = b
+		} else {
+			b = v
+			for order[j] != b {
+				j++
+			}
+			result[i] = byte(j)
+		}
+		copy(order[1:j+1], order[:j])
+		order[0] = b
+	}
+	return result
+}
+
+/* zeroRunLength(): Codes move-to-front output as symbols, shortening runs of zeros. */
+func zeroRunLength(data []byte) []uint16 {
+	symbols := make([]uint16, 0, len(data)/2)
+	run := 0
+	flushRun := func() {
+		for n := run + 1; n > 1; n >>= 1 {
+			symbols = append(symbols, uint16(n&1))
+		}
+		run = 0
+	}
+	for _, b := range data {
+		if b == 0 {
+			run++
+			continue
+		}
+		flushRun()
+		symbols = append(symbols, uint16(b)+1)
+	}
+	flushRun()
+	return symbols
+}
+
+/* encodeBWTBlock(): Encodes a block with the BWT pipeline, followed by its checksum if
+* the header asks for block checksums.
+ */
+func encodeBWTBlock(data []byte, h *Header, crc uint32) ([]byte, error) {
+	transformed, primary := BWT(data)
+	symbols := zeroRunLength(moveToFront(transformed, false))
+
+	frequencyMap := map[int]int{pseudoEOF: 1}
+	for _, s := range symbols {
+		frequencyMap[int(s)]++
+	}
+	limit := h.MaxCodeLength
+	if limit == 0 {
+		limit = maxCodeLength
+	}
+	huffmanTree := HuffTree{}
+	if err := huffmanTree.MakeLengthLimitedTree(frequencyMap, limit); err != nil {
+		return nil, err
+	}
+	lengthMap := huffmanTree.CodeLengths()
+	codeMap := canonicalCodes(lengthMap)
+
+	var buf bytes.Buffer
+	buf.Grow(len(data) / 3)
+	writeUvarint(&buf, uint64(primary))
+	writeCodeLengths(&buf, lengthMap)
+	bitWriter := NewBitWriter(&buf)
+	for _, s := range symbols {
+		code := codeMap[int(s)]
+		bitWriter.WriteBits(code.bits, code.length)
+	}
+	code := codeMap[pseudoEOF]
+	bitWriter.WriteBits(code.bits, code.length)
+	bitWriter.Flush()
+
+	if h.BlockChecksums {
+		writeChecksum(&buf, crc)
+	}
+	return buf.Bytes(), nil
+}
+
+/* readBWTTable(): Reads the primary index and code table at the start of a BWT block. */
+func readBWTTable(br *BitReader, h *Header) (primary int, lengthMap map[int]int, err error) {
+	index, err := readUvarint(br)
+	if err != nil {
+		return 0, nil, err
+	}
+	if index > MaxBlockSize {
+		return 0, nil, fmt.Errorf("%w: invalid primary index %d", ErrCorruptInput, index)
+	}
+	if lengthMap, err = readCodeLengths(br); err != nil {
+		return 0, nil, err
+	}
+	if len(lengthMap) == 0 {
+		return 0, nil, ErrEmptyCodeTable
+	}
+	for k, l := range lengthMap {
+		if k != pseudoEOF && k >= bwtSymbols {
+			return 0, nil, fmt.Errorf("%w: invalid symbol %d in code table", ErrCorruptInput, k)
+		}
+		if h.MaxCodeLength != 0 && l > h.MaxCodeLength {
+			return 0, nil, fmt.Errorf("%w: code length %d exceeds the limit of %d", ErrCorruptInput, l, h.MaxCodeLength)
+		}
+	}
+	return int(index), lengthMap, nil
+}
+
+/* decodeBWTBlock(): Decodes the body of a BWT block holding size bytes of input. If
+* counts is not nil, the symbols read are counted in it.
+ */
+func decodeBWTBlock(br *BitReader, size int, h *Header, counts map[int]int) ([]byte, error) {
+	if size > MaxBWTBlockSize {
+		return nil, fmt.Errorf("%w: BWT block size %d is too large", ErrCorruptInput, size)
+	}
+	primary, lengthMap, err := readBWTTable(br, h)
+	if err != nil {
+		return nil, err
+	}
+	table := newDecodeTable(canonicalCodes(lengthMap))
+
+	var mtf []byte
+	run, runBit := 0, 0
+	for {
+		s, err := table.decode(br)
+		if err != nil {
+			return nil, err
+		}
+		if counts != nil {
+			counts[s]++
+		}
+		if s == bwtRunA || s == bwtRunB {
+			//	A run can't be longer than the block, which also bounds the shift
+			run += (s + 1) << runBit
+			runBit++
+			if len(mtf)+run > size {
+				return nil, fmt.Errorf("%w: block is longer than its recorded size", ErrCorruptInput)
+			}
+			continue
+		}
+		mtf = append(mtf, make([]byte, run)...)
+		run, runBit = 0, 0
+		if s == pseudoEOF {
+			break
+		}
+		if len(mtf) >= size {
+			return nil, fmt.Errorf("%w: block is longer than its recorded size", ErrCorruptInput)
+		}
+		mtf = append(mtf, byte(s-1))
+	}
+
+	if len(mtf) != size {
+		return nil, fmt.Errorf("%w: decoded %d bytes, block size is %d", ErrCorruptInput, len(mtf), size)
+	}
+	return InverseBWT(moveToFront(mtf, true), primary)
+}
+
+/* bwtSymbolName(): Returns how Inspect() names a symbol of a BWT block. */
+func bwtSymbolName(symbol int) string {
+	switch symbol {
+	case pseudoEOF:
+		return "EOF"
+	case bwtRunA:
+		return "RUNA"
+	case bwtRunB:
+		return "RUNB"
+	}
+	return fmt.Sprintf("MTF %d", symbol-1)
+}
@@ -0,0 +1,172 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Canonical Huffman codes. Only the length of each character's code is stored in a
+* .huff file, and the codes themselves are rebuilt from the lengths: characters are
+* sorted by code length and then by value, and each is given the next available code
+* of its length. The encoder and decoder therefore always agree on the codes, and the
+* same input always produces the same output.
+ */
+
+package huffmyfile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Codes are built in a uint64, so no code may be longer than 64 bits
+const maxCodeLength = 64
+
+/* huffCode: A code stored in the low bits of an integer, most significant bit first. */
+type huffCode struct {
+	bits   uint64
+	length uint8
+}
+
+/* canonicalCodes(): Returns the canonical code for each character in lengthMap. */
+func canonicalCodes(lengthMap map[int]int) map[int]huffCode {
+	symbols := make([]int, 0, len(lengthMap))
+	for k := range lengthMap {
+		symbols = append(symbols, k)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		li, lj := lengthMap[symbols[i]], lengthMap[symbols[j]]
+		if li != lj {
+			return li < lj
+		}
+		return symbols[i] < symbols[j]
+	})
+
+	//	Codes of the same length are consecutive binary numbers. Moving on to a longer
+	//	length appends zeros to the code following the last shorter one.
+	codeMap := make(map[int]huffCode, len(symbols))
+	var code uint64
+	length := 0
+	for i, k := range symbols {
+		if i > 0 {
+			code++
+		}
+		code <<= lengthMap[k] - length
+		length = lengthMap[k]
+		codeMap[k] = huffCode{bits: code, length: uint8(length)}
+	}
+	return codeMap
+}
+
+/* maxLength(): Returns the length of the longest code in lengthMap. */
+func maxLength(lengthMap map[int]int) int {
+	longest := 0
+	for _, l := range lengthMap {
+		if l > longest {
+			longest = l
+		}
+	}
+	return longest
+}
+
+/* checkCodeLengths(): Makes sure the code lengths describe a complete prefix code, i.e.
+* that the codes neither run out nor leave any sequence of bits undecodable.
+ */
+func checkCodeLengths(lengthMap map[int]int) error {
+	count := make([]int, maxCodeLength+1)
+	for _, l := range lengthMap {
+		if l < 1 || l > maxCodeLength {
+			return fmt.Errorf("%w: invalid code length %d", ErrCorruptInput, l)
+		}
+		count[l]++
+	}
+
+	//	Count the codes still available at each length. Running out means the lengths
+	//	are over-subscribed, having more left than characters to use them means the
+	//	code is incomplete.
+	left, remaining := 1, len(lengthMap)
+	for l := 1; l <= maxCodeLength && remaining > 0; l++ {
+		left = left*2 - count[l]
+		remaining -= count[l]
+		if left < 0 || left > remaining {
+			return fmt.Errorf("%w: code lengths do not form a valid prefix code", ErrCorruptInput)
+		}
+	}
+	return nil
+}
+
+/* writeCodeLengths(): Writes the code length table. The length of the pseudo-EOF's
+* code comes first, and is 0 if the table is empty. It is followed by the number of
+* other characters, then each character in ascending order as the gap from the
+* previous character and the length of its code.
+ */
+func writeCodeLengths(w byteWriter, lengthMap map[int]int) error {
+	symbols := make([]int, 0, len(lengthMap))
+	for k := range lengthMap {
+		if k != pseudoEOF {
+			symbols = append(symbols, k)
+		}
+	}
+	sort.Ints(symbols)
+
+	w.WriteByte(byte(lengthMap[pseudoEOF]))
+	writeUvarint(w, uint64(len(symbols)))
+	previous := -1
+	for _, k := range symbols {
+		writeUvarint(w, uint64(k-previous-1))
+		w.WriteByte(byte(lengthMap[k]))
+		previous = k
+	}
+	_, err := w.Write(nil)
+	return err
+}
+
+/* readCodeLengths(): Reads a code length table written by writeCodeLengths() and
+* checks that it is valid.
+ */
+func readCodeLengths(r io.ByteReader) (map[int]int, error) {
+	lengthMap := make(map[int]int)
+
+	eofLength, err := r.ReadByte()
+	if err != nil {
+		return nil, truncated(err)
+	}
+	n, err := readUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if eofLength == 0 {
+		if n != 0 {
+			return nil, fmt.Errorf("%w: code table has no end of data marker", ErrCorruptInput)
+		}
+		return lengthMap, nil
+	}
+	lengthMap[pseudoEOF] = int(eofLength)
+
+	previous := -1
+	for i := uint64(0); i < n; i++ {
+		gap, err := readUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if gap >= uint64(pseudoEOF-previous-1) {
+			return nil, fmt.Errorf("%w: invalid symbol in code table", ErrCorruptInput)
+		}
+		k := previous + 1 + int(gap)
+
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, truncated(err)
+		}
+		lengthMap[k] = int(length)
+		previous = k
+	}
+
+	if err := checkCodeLengths(lengthMap); err != nil {
+		return nil, err
+	}
+	return lengthMap, nil
+}
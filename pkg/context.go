@@ -0,0 +1,406 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Order-1 context modeling, used with MethodContext. In text, which character comes
+* next depends a lot on the one before it, so each symbol is coded with a code table
+* chosen by its context: the previous symbol, or for runes past 0xFF a hash of it. One
+* table per context would take up more room than it saves, so contexts whose symbols
+* have similar frequencies are merged into a shared table, as long as that makes the
+* block smaller, down to at most maxContextTables tables. A context block replaces
+* the code table of block.go with:
+*
+*	tables          varint   number of code tables
+*	context map              only if there is more than one table: varint number of
+*	                         contexts used, then for each in ascending order the gap
+*	                         from the previous one and its table as a byte
+*	code tables              see writeCodeLengths(), every table has a pseudo-EOF
+*
+* The first symbol of a block is in contextStart.
+ */
+
+package huffmyfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+const (
+	numContexts      = 257 // Previous bytes or hashed runes, and the start of a block
+	contextStart     = 256 // Context of the first symbol of a block
+	maxContextTables = 32  // Most code tables in a block
+
+	//	Estimated bits taken up by a code table and by each of its symbols
+	tableCostBits  = 16
+	symbolCostBits = 12
+)
+
+/* contextOf(): Returns the context that symbol selects for the symbol after it. */
+func contextOf(symbol int) int {
+	if symbol < 0x100 {
+		return symbol
+	}
+	//	Runes past 0xFF share the upper half of the contexts, which Latin-1 text uses
+	//	much less than ASCII
+	return 0x80 + int(uint32(symbol)*2654435761>>25)
+}
+
+/* contextCluster: Contexts sharing a code table, while they are being merged. */
+type contextCluster struct {
+	contexts  []int
+	frequency map[int]int
+	cost      float64 // Estimated bits to code the symbols and store the table
+}
+
+/* clusterCost(): Estimates the bits taken up by a code table for frequencyMap and
+* the symbols coded with it, from the entropy of the frequencies.
+ */
+func clusterCost(frequencyMap map[int]int) float64 {
+	total := 0
+	symbols := make([]int, 0, len(frequencyMap))
+	for k, f := range frequencyMap {
+		total += f
+		symbols = append(symbols, k)
+	}
+	//	Floating-point addition is not associative, so the terms are added in order of
+	//	symbol rather than in random map order. Otherwise a tie between two merges in
+	//	clusterContexts() could go either way, and the same input give different output.
+	sort.Ints(symbols)
+	bits := float64(tableCostBits + symbolCostBits*len(frequencyMap))
+	for _, k := range symbols {
+		f := float64(frequencyMap[k])
+		//	The conversion rounds the product, so the compiler cannot fuse the multiply
+		//	and add on the platforms that have an instruction for it
+		bits += float64(f * math.Log2(float64(total)/f))
+	}
+	return bits
+}
+
+/* mergeFrequencies(): Returns the combined frequencies of a and b. */
+func mergeFrequencies(a, b map[int]int) map[int]int {
+	merged := make(map[int]int, len(a)+len(b))
+	for k, f := range a {
+		merged[k] = f
+	}
+	for k, f := range b {
+		merged[k] += f
+	}
+	return merged
+}
+
+/* clusterContexts(): Merges the contexts in counts, which holds the frequency of each
+* symbol in each context, into at most maxContextTables clusters. The pair of
+* clusters whose merge saves the most bits is merged until no merge saves any and
+* there are few enough clusters left. Clusters are returned in order of their first
+* context.
+ */
+func clusterContexts(counts map[int]map[int]int) []*contextCluster {
+	contexts := make([]int, 0, len(counts))
+	for ctx := range counts {
+		contexts = append(contexts, ctx)
+	}
+	sort.Ints(contexts)
+	clusters := make([]*contextCluster, len(contexts))
+	for i, ctx := range contexts {
+		clusters[i] = &contextCluster{contexts: []int{ctx}, frequency: counts[ctx], cost: clusterCost(counts[ctx])}
+	}
+
+	//	delta[i][j], for i < j, is the change in bits from merging clusters i and j
+	mergeDelta := func(a, b *contextCluster) float64 {
+		return clusterCost(mergeFrequencies(a.frequency, b.frequency)) - a.cost - b.cost
+	}
+	delta := make([][]float64, len(clusters))
+	for i := range clusters {
+		delta[i] = make([]float64, len(clusters))
+		for j := i + 1; j < len(clusters); j++ {
+			delta[i][j] = mergeDelta(clusters[i], clusters[j])
+		}
+	}
+
+	alive := len(clusters)
+	for alive > 1 {
+		bi, bj := -1, -1
+		for i := range clusters {
+			if clusters[i] == nil {
+				continue
+			}
+			for j := i + 1; j < len(clusters); j++ {
+				if clusters[j] != nil && (bi < 0 || delta[i][j] < delta[bi][bj]) {
+					bi, bj = i, j
+				}
+			}
+		}
+		if delta[bi][bj] >= 0 && alive <= maxContextTables {
+			break
+		}
+
+		//	The merged cluster takes the place of the first, keeping them in order
+		a, b := clusters[bi], clusters[bj]
+		a.contexts = append(a.contexts, b.contexts...)
+		a.frequency = mergeFrequencies(a.frequency, b.frequency)
+		a.cost = clusterCost(a.frequency)
+		clusters[bj] = nil
+		alive--
+		for k, c := range clusters {
+			switch {
+			case c == nil || k == bi:
+			case k < bi:
+				delta[k][bi] = mergeDelta(c, a)
+			default:
+				delta[bi][k] = mergeDelta(a, c)
+			}
+		}
+	}
+
+	var result []*contextCluster
+	for _, c := range clusters {
+		if c != nil {
+			sort.Ints(c.contexts)
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+/* encodeContextBlock(): Encodes a block with order-1 context modeling, followed by its
+* checksum if the header asks for block checksums. Also returns the size the block
+* would have taken with a single code table, with the same checksum.
+ */
+func encodeContextBlock(data []byte, h *Header, crc uint32) (encoded []byte, order0Size int, err error) {
+	//	Count each symbol in its context. The context after the last symbol is
+	//	included, even if it is otherwise unused, since the end of the block is coded
+	//	in it.
+	counts := make(map[int]map[int]int)
+	ctx := contextStart
+	for rest := data; len(rest) > 0; {
+		c, size := h.Alphabet.nextSymbol(rest)
+		rest = rest[size:]
+		if counts[ctx] == nil {
+			counts[ctx] = make(map[int]int)
+		}
+		counts[ctx][c]++
+		ctx = contextOf(c)
+	}
+	if counts[ctx] == nil {
+		counts[ctx] = make(map[int]int)
+	}
+	clusters := clusterContexts(counts)
+
+	//	The cost of a table is only estimated when clustering, so for small blocks a
+	//	single table can still come out smaller. A single table is order-0 coding
+	//	apart from the byte giving the number of tables.
+	if encoded, err = writeContextBlock(data, h, clusters); err != nil {
+		return nil, 0, err
+	}
+	order0Size = len(encoded) - 1
+	if len(clusters) > 1 {
+		all := &contextCluster{frequency: make(map[int]int)}
+		for _, cluster := range clusters {
+			all.contexts = append(all.contexts, cluster.contexts...)
+			all.frequency = mergeFrequencies(all.frequency, cluster.frequency)
+		}
+		single, err := writeContextBlock(data, h, []*contextCluster{all})
+		if err != nil {
+			return nil, 0, err
+		}
+		order0Size = len(single) - 1
+		if len(single) < len(encoded) {
+			encoded = single
+		}
+	}
+
+	buf := bytes.NewBuffer(encoded)
+	if h.BlockChecksums {
+		writeChecksum(buf, crc)
+		order0Size += checksumSize
+	}
+	return buf.Bytes(), order0Size, nil
+}
+
+/* writeContextBlock(): Returns the tables and body of a context block coded with the
+* given clusters of contexts.
+ */
+func writeContextBlock(data []byte, h *Header, clusters []*contextCluster) ([]byte, error) {
+	limit := h.MaxCodeLength
+	if limit == 0 {
+		limit = maxCodeLength
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data) / 2)
+	writeUvarint(&buf, uint64(len(clusters)))
+	if len(clusters) > 1 {
+		writeContextMap(&buf, clusters)
+	}
+	var tableOf [numContexts]int
+	codeMaps := make([]map[int]huffCode, len(clusters))
+	for t, cluster := range clusters {
+		for _, ctx := range cluster.contexts {
+			tableOf[ctx] = t
+		}
+		//	No table is left with only the pseudo-EOF, as clusterContexts() always merges
+		//	away a context without symbols
+		frequencyMap := mergeFrequencies(cluster.frequency, map[int]int{pseudoEOF: 1})
+		huffmanTree := HuffTree{}
+		if err := huffmanTree.MakeLengthLimitedTree(frequencyMap, limit); err != nil {
+			return nil, err
+		}
+		lengthMap := huffmanTree.CodeLengths()
+		writeCodeLengths(&buf, lengthMap)
+		codeMaps[t] = canonicalCodes(lengthMap)
+	}
+
+	bitWriter := NewBitWriter(&buf)
+	ctx := contextStart
+	for rest := data; len(rest) > 0; {
+		c, size := h.Alphabet.nextSymbol(rest)
+		rest = rest[size:]
+		code := codeMaps[tableOf[ctx]][c]
+		bitWriter.WriteBits(code.bits, code.length)
+		ctx = contextOf(c)
+	}
+	code := codeMaps[tableOf[ctx]][pseudoEOF]
+	bitWriter.WriteBits(code.bits, code.length)
+	bitWriter.Flush()
+	return buf.Bytes(), nil
+}
+
+/* writeContextMap(): Writes which table each context that is used codes with. */
+func writeContextMap(w byteWriter, clusters []*contextCluster) {
+	tableOf := make(map[int]int)
+	var contexts []int
+	for t, cluster := range clusters {
+		for _, ctx := range cluster.contexts {
+			tableOf[ctx] = t
+			contexts = append(contexts, ctx)
+		}
+	}
+	sort.Ints(contexts)
+	writeUvarint(w, uint64(len(contexts)))
+	previous := -1
+	for _, ctx := range contexts {
+		writeUvarint(w, uint64(ctx-previous-1))
+		w.WriteByte(byte(tableOf[ctx]))
+		previous = ctx
+	}
+}
+
+/* contextTables: The code tables of a context block. */
+type contextTables struct {
+	tableOf    [numContexts]int // Table of each context, -1 for contexts that are not used
+	lengthMaps []map[int]int
+	tables     []*decodeTable
+}
+
+/* readContextTables(): Reads and checks the context map and code tables at the start
+* of a context block.
+ */
+func readContextTables(r io.ByteReader, h *Header) (*contextTables, error) {
+	n, err := readUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || n > maxContextTables {
+		return nil, fmt.Errorf("%w: invalid number of code tables %d", ErrCorruptInput, n)
+	}
+	t := &contextTables{}
+	if n == 1 {
+		for ctx := range t.tableOf {
+			t.tableOf[ctx] = 0
+		}
+	} else {
+		for ctx := range t.tableOf {
+			t.tableOf[ctx] = -1
+		}
+		used, err := readUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if used > numContexts {
+			return nil, fmt.Errorf("%w: too many contexts", ErrCorruptInput)
+		}
+		previous := -1
+		for i := uint64(0); i < used; i++ {
+			gap, err := readUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if gap >= uint64(numContexts-previous-1) {
+				return nil, fmt.Errorf("%w: invalid context in context map", ErrCorruptInput)
+			}
+			ctx := previous + 1 + int(gap)
+			table, err := r.ReadByte()
+			if err != nil {
+				return nil, truncated(err)
+			}
+			if uint64(table) >= n {
+				return nil, fmt.Errorf("%w: invalid code table %d in context map", ErrCorruptInput, table)
+			}
+			t.tableOf[ctx] = int(table)
+			previous = ctx
+		}
+	}
+
+	for i := uint64(0); i < n; i++ {
+		lengthMap, err := readCodeLengths(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(lengthMap) == 0 {
+			return nil, ErrEmptyCodeTable
+		}
+		for k, l := range lengthMap {
+			if !h.Alphabet.validSymbol(k) {
+				return nil, fmt.Errorf("%w: invalid symbol %d in code table", ErrCorruptInput, k)
+			}
+			if h.MaxCodeLength != 0 && l > h.MaxCodeLength {
+				return nil, fmt.Errorf("%w: code length %d exceeds the limit of %d", ErrCorruptInput, l, h.MaxCodeLength)
+			}
+		}
+		t.lengthMaps = append(t.lengthMaps, lengthMap)
+		t.tables = append(t.tables, newDecodeTable(canonicalCodes(lengthMap)))
+	}
+	return t, nil
+}
+
+/* decoder(): Returns a function decoding the symbols of a block one at a time, each
+* with the table of the previous symbol's context. If counts is not nil, the symbols
+* read with each table are counted in it.
+ */
+func (t *contextTables) decoder(counts []map[int]int) func(br *BitReader) (int, error) {
+	ctx := contextStart
+	return func(br *BitReader) (int, error) {
+		table := t.tableOf[ctx]
+		if table < 0 {
+			return 0, fmt.Errorf("%w: symbol in a context with no code table", ErrCorruptInput)
+		}
+		c, err := t.tables[table].decode(br)
+		if err != nil {
+			return 0, err
+		}
+		if counts != nil {
+			counts[table][c]++
+		}
+		ctx = contextOf(c)
+		return c, nil
+	}
+}
+
+/* contextName(): Returns how Inspect() names a context. */
+func contextName(ctx int, alphabet Alphabet) string {
+	switch {
+	case ctx == contextStart:
+		return "start"
+	case alphabet == AlphabetBytes || ctx < 0x80:
+		return alphabet.quote(ctx)
+	}
+	return fmt.Sprintf("#%d", ctx)
+}
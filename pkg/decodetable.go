@@ -0,0 +1,133 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Table-driven decoding, as in zlib's inflate. Rather than reading one bit at a time
+* and checking whether the bits so far make up a code, the decoder peeks at the next
+* tableBits bits and looks them up in a table. Every index starting with a code of at
+* most tableBits bits holds that code's character, so one lookup decodes it. Longer
+* codes are found through an overflow sub-table indexed by the bits following the
+* first tableBits, and so on for codes longer still.
+ */
+
+package huffmyfile
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Number of bits looked up at a time by a decodeTable
+const tableBits = 9
+
+/* tableEntry: Either a character and the number of bits of its code left to consume
+* at this level, or a link to the sub-table decoding the rest of longer codes.
+ */
+type tableEntry struct {
+	value  int   // Character, or offset of the sub-table for a link
+	bits   uint8 // Bits to consume, or index width of the sub-table for a link. 0 if invalid
+	isLink bool
+}
+
+/* decodeTable: The primary table, followed by all of its sub-tables. */
+type decodeTable struct {
+	entries     []tableEntry
+	primaryBits uint8
+}
+
+/* tableCode: A code with the character it represents, used while building tables. */
+type tableCode struct {
+	symbol int
+	code   huffCode
+}
+
+/* newDecodeTable(): Builds the decoding table for a set of prefix codes. */
+func newDecodeTable(codeMap map[int]huffCode) *decodeTable {
+	codes := make([]tableCode, 0, len(codeMap))
+	for k, c := range codeMap {
+		codes = append(codes, tableCode{symbol: k, code: c})
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].symbol < codes[j].symbol })
+
+	t := &decodeTable{}
+	t.primaryBits = t.build(codes, 0)
+	return t
+}
+
+/* build(): Appends a table for codes whose first skip bits have already been consumed,
+* along with its sub-tables, and returns the width of its index.
+ */
+func (t *decodeTable) build(codes []tableCode, skip uint8) uint8 {
+	var longest uint8
+	for _, c := range codes {
+		if c.code.length-skip > longest {
+			longest = c.code.length - skip
+		}
+	}
+	n := longest
+	if n > tableBits {
+		n = tableBits
+	}
+
+	offset := len(t.entries)
+	t.entries = append(t.entries, make([]tableEntry, 1<<n)...)
+
+	//	Codes that end in this table fill every index they are a prefix of. Longer
+	//	codes are grouped by their next n bits to build the sub-tables.
+	groups := make(map[uint64][]tableCode)
+	for _, c := range codes {
+		remaining := c.code.length - skip
+		bits := c.code.bits & (1<<remaining - 1)
+		if remaining <= n {
+			first := bits << (n - remaining)
+			for i := uint64(0); i < 1<<(n-remaining); i++ {
+				t.entries[offset+int(first+i)] = tableEntry{value: c.symbol, bits: remaining}
+			}
+		} else {
+			index := bits >> (remaining - n)
+			groups[index] = append(groups[index], c)
+		}
+	}
+
+	indexes := make([]uint64, 0, len(groups))
+	for index := range groups {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	for _, index := range indexes {
+		subOffset := len(t.entries)
+		subBits := t.build(groups[index], skip+n)
+		t.entries[offset+int(index)] = tableEntry{value: subOffset, bits: subBits, isLink: true}
+	}
+	return n
+}
+
+/* decode(): Reads the next code from br and returns its character. */
+func (t *decodeTable) decode(br *BitReader) (int, error) {
+	offset, n := 0, t.primaryBits
+	for {
+		bits, available := br.peekBits(n)
+		e := t.entries[offset+int(bits)]
+		if e.isLink {
+			if available < n {
+				return 0, truncated(br.err)
+			}
+			br.consume(n)
+			offset, n = e.value, e.bits
+			continue
+		}
+		if e.bits == 0 {
+			return 0, fmt.Errorf("%w: bit sequence does not match any code", ErrCorruptInput)
+		}
+		if available < e.bits {
+			return 0, truncated(br.err)
+		}
+		br.consume(e.bits)
+		return e.value, nil
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Dictionaries are code tables trained on a sample of typical input and shared ahead
+* of time, so small files can reference one by its ID instead of storing their own
+* table. The ID is the checksum of the dictionary's contents, so the same training
+* always gives the same ID and a damaged dictionary file is detected when it is read.
+*
+*	magic           4 bytes  "HUFD"
+*	version         1 byte   dictionaryVersion
+*	alphabet        1 byte   as in the header of a .huff file
+*	ID              4 bytes  big-endian CRC-32C of the alphabet byte and code table
+*	code table               see writeCodeLengths()
+ */
+
+package huffmyfile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"unicode/utf8"
+)
+
+const (
+	dictionaryMagic   = "HUFD"
+	dictionaryVersion = 1
+)
+
+/* Dictionary: A code table trained on sample input, selected with Options.Dictionary. */
+type Dictionary struct {
+	ID       uint32   // Identifies the dictionary in files that use it, never 0
+	Alphabet Alphabet // Alphabet of the symbols in the table
+
+	lengthMap map[int]int
+	maxLength int // Length of the longest code
+	codeMap   map[int]huffCode
+	table     *decodeTable
+}
+
+/* TrainDictionary(): Counts the symbols in every sample and builds a dictionary from
+* their combined frequencies. Every byte, or every ASCII character for runes, is given
+* a code even if the samples do not contain it, so that input differing slightly from
+* the samples can still use the dictionary.
+ */
+func TrainDictionary(samples [][]byte, alphabet Alphabet) (*Dictionary, error) {
+	if alphabet == AlphabetAuto {
+		alphabet = AlphabetRunes
+		for _, sample := range samples {
+			if !utf8.Valid(sample) {
+				alphabet = AlphabetBytes
+				break
+			}
+		}
+	}
+
+	frequencyMap := map[int]int{pseudoEOF: 1}
+	for _, sample := range samples {
+		for k, n := range countSymbols(sample, alphabet) {
+			frequencyMap[k] += n
+		}
+	}
+	base := utf8.RuneSelf
+	if alphabet == AlphabetBytes {
+		base = 256
+	}
+	for k := 0; k < base; k++ {
+		frequencyMap[k]++
+	}
+
+	huffmanTree := HuffTree{}
+	if err := huffmanTree.MakeLengthLimitedTree(frequencyMap, maxCodeLength); err != nil {
+		return nil, err
+	}
+	return newDictionary(alphabet, huffmanTree.CodeLengths()), nil
+}
+
+/* newDictionary(): Builds a dictionary and its ID from a valid code length table. */
+func newDictionary(alphabet Alphabet, lengthMap map[int]int) *Dictionary {
+	codeMap := canonicalCodes(lengthMap)
+	d := &Dictionary{
+		Alphabet:  alphabet,
+		lengthMap: lengthMap,
+		maxLength: maxLength(lengthMap),
+		codeMap:   codeMap,
+		table:     newDecodeTable(codeMap),
+	}
+	d.ID = crc32.Checksum(d.contents(), crcTable)
+	if d.ID == 0 {
+		d.ID = 1
+	}
+	return d
+}
+
+/* contents(): Returns the alphabet byte and code table the ID is computed from. */
+func (d *Dictionary) contents() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(alphabetByte(d.Alphabet))
+	writeCodeLengths(&buf, d.lengthMap)
+	return buf.Bytes()
+}
+
+/* Symbols(): Returns the number of symbols the dictionary has codes for, not counting
+* the end of a block.
+ */
+func (d *Dictionary) Symbols() int {
+	return len(d.lengthMap) - 1
+}
+
+/* covers(): Reports whether the dictionary has a code for every symbol in frequencyMap. */
+func (d *Dictionary) covers(frequencyMap map[int]int) bool {
+	for k := range frequencyMap {
+		if _, ok := d.lengthMap[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+/* WriteTo(): Writes the dictionary file to w. */
+func (d *Dictionary) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(dictionaryMagic)
+	buf.WriteByte(dictionaryVersion)
+	contents := d.contents()
+	buf.WriteByte(contents[0])
+	binary.Write(&buf, binary.BigEndian, d.ID)
+	buf.Write(contents[1:])
+	return buf.WriteTo(w)
+}
+
+/* ReadDictionary(): Reads a dictionary file written by WriteTo(). */
+func ReadDictionary(r io.Reader) (*Dictionary, error) {
+	br := bufio.NewReader(r)
+	buf := make([]byte, len(dictionaryMagic)+2+4)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrNotDictionary
+		}
+		return nil, err
+	}
+	if string(buf[:len(dictionaryMagic)]) != dictionaryMagic {
+		return nil, ErrNotDictionary
+	}
+	if version := buf[len(dictionaryMagic)]; version != dictionaryVersion {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+	}
+	alphabet, err := parseAlphabetByte(buf[len(dictionaryMagic)+1])
+	if err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint32(buf[len(dictionaryMagic)+2:])
+
+	lengthMap, err := readCodeLengths(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(lengthMap) == 0 {
+		return nil, ErrEmptyCodeTable
+	}
+	for k := range lengthMap {
+		if !alphabet.validSymbol(k) {
+			return nil, fmt.Errorf("%w: invalid symbol %d in code table", ErrCorruptInput, k)
+		}
+	}
+	d := newDictionary(alphabet, lengthMap)
+	if d.ID != id {
+		return nil, fmt.Errorf("%w: dictionary", ErrChecksum)
+	}
+	return d, nil
+}
@@ -10,160 +10,196 @@ package huffmyfile
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
 	"os"
 	"path"
-	"strconv"
+	"path/filepath"
 	"strings"
 )
 
+/* Options: Settings used when compressing, shared by Encoder and Writer. The zero
+* value selects the defaults.
+ */
+type Options struct {
+	Alphabet  Alphabet // What each coded symbol represents, chosen from the input by default
+	BlockSize int      // Bytes of input compressed with each code table, DefaultBlockSize if 0
+	Threads   int      // Blocks compressed or decompressed at once, runtime.GOMAXPROCS(0) if 0
+
+	//	Store a checksum with every block as well as for the whole input, so that a
+	//	corrupt block is found before its contents are returned
+	BlockChecksums bool
+
+	//	How symbols are coded. CodingAdaptive stores no code tables, which suits small
+	//	inputs, but cannot be combined with MaxCodeLength or Dictionary.
+	Coding Coding
+
+	//	Longest code allowed in bits, up to 64, so a decoder can rely on a bound when
+	//	sizing its tables. Codes are not limited beyond 64 bits if 0.
+	MaxCodeLength int
+
+	//	Code blocks with the dictionary's code table where that is smaller than
+	//	storing their own. The same dictionary is needed to decompress the output.
+	Dictionary *Dictionary
+
+	//	How blocks are transformed before Huffman coding. MethodBWT works on bytes
+	//	in blocks of at most MaxBWTBlockSize, and cannot be combined with
+	//	CodingAdaptive, Dictionary or Level, and neither can MethodContext.
+	Method Method
+
+	//	Compression level from 1 to MaxLevel to replace repeated strings with LZ77
+	//	matches before Huffman coding, searching harder for matches at higher levels.
+	//	Symbols are Huffman coded alone if 0. Cannot be combined with CodingAdaptive
+	//	or Dictionary.
+	Level int
+
+	//	Furthest back in bytes, from MinWindow to MaxWindow, that LZ77 looks for
+	//	matches, DefaultWindow if 0. Larger windows find more matches but take longer.
+	Window int
+}
+
 type Encoder struct {
-	frequencyMap   map[int]int
-	codeMap        map[int]string
-	reverseCodeMap map[string]int
+	Options
+
+	//	If set, Decode() skips blocks that fail to decode and writes the rest of the
+	//	input, returning ErrCorruptInput once done if any were skipped.
+	SkipCorruptBlocks bool
+
+	//	If set, Encode() and Decode() replace an existing output file rather than
+	//	failing with an error matching fs.ErrExist.
+	Overwrite bool
+
+	//	If set, Encode() does not store the input file's name, permissions and
+	//	modification time, so the output only depends on the contents, and Decode()
+	//	does not restore them.
+	NoName bool
+
+	//	If set, Encode() and Decode() remove the input file once the output has been
+	//	written in full and closed. Encode() first checks that the output decodes.
+	RemoveInput bool
+
+	//	If set, Encode() and Decode() do not print their progress and statistics
+	Quiet bool
+}
+
+/* println(): Prints a progress message to standard error, unless Quiet is set. */
+func (e *Encoder) println(s string) {
+	if !e.Quiet {
+		println(s)
+	}
 }
 
 /* EncodeToDefaultOutputFile():
 * Wrapper for Encode() so an output file name doesn't need to be specified.
 * Creates an output file name based on the input file name.
  */
-func (e *Encoder) EncodeToDefaultOutputFile(inputFileName string) {
+func (e *Encoder) EncodeToDefaultOutputFile(inputFileName string) error {
+	return Encode(inputFileName, EncodedFileName(inputFileName), e)
+}
 
+/* EncodedFileName(): Returns the name EncodeToDefaultOutputFile() gives the output
+* for an input file, which replaces its extension with .huff.
+ */
+func EncodedFileName(inputFileName string) string {
 	extension := path.Ext(inputFileName)
 	nameWithoutExtension := inputFileName[:len(inputFileName)-len(extension)]
-	outputFileName := nameWithoutExtension + ".huff"
-
-	Encode(inputFileName, outputFileName, e)
+	return nameWithoutExtension + ".huff"
 }
 
 /* Encode(): Encodes a text file to a .huff file. */
-func Encode(inputFileName, compressedFileName string, e *Encoder) {
+func Encode(inputFileName, compressedFileName string, e *Encoder) (err error) {
+	//Remove input file on success if asked to, after it has been closed
+	defer func() {
+		if err == nil && e.RemoveInput {
+			if err = Verify(compressedFileName, e); err == nil {
+				err = os.Remove(inputFileName)
+			}
+		}
+	}()
+
 	//Open input file
 	inputFile, err := os.Open(inputFileName)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	//close inputFile on exit & check for its returned error
-	defer func() {
-		if err := inputFile.Close(); err != nil {
-			panic(err)
-		}
-	}()
+	//close inputFile on exit
+	defer inputFile.Close()
 
-	//Open output file
-	outputFile, err := os.Create(compressedFileName)
+	//Open output file under a temporary name, removed on exit unless committed
+	outputFile, err := createOutputFile(inputFile, compressedFileName, e.Overwrite)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	//close outputFile on exit
-	defer func() {
-		if err := outputFile.Close(); err != nil {
-			panic(err)
-		}
-	}()
+	defer outputFile.Abort()
 
-	//Create Reader & Writer
-	reader := bufio.NewReader(inputFile)
-	writer := bufio.NewWriter(outputFile)
-	bitWriter := NewBitWriter(outputFile)
+	inputInfo, err := inputFile.Stat()
+	if err != nil {
+		return err
+	}
 
-	//Generate Frequency Map
-	println("Building frequency map...")
-	frequencyMap := makeFrequencyMap(inputFileName)
+	//Compress input file into output file, then give it its real name
+	e.println("Encoding file...")
+	writer := e.newWriter(outputFile, inputInfo)
+	if _, err := io.Copy(writer, inputFile); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	if err := outputFile.Commit(); err != nil {
+		return err
+	}
+	if e.Quiet {
+		return nil
+	}
 
-	//Stop encoding if input file is empty
-	if len(frequencyMap) == 0 {
+	//Stop here if input file is empty
+	if writer.size == 0 {
 		println("Input is empty.")
 		println("Encoding complete.")
-		return
-	}
-	//Generate Huffman Tree using frequency map
-	println("Generating Huffman tree...")
-	huffmanTree := HuffTree{}
-	huffmanTree.MakeHuffmanTree(frequencyMap)
-
-	//Generate Code Map from Huffman Tree
-	codeMap := huffmanTree.CodeMap()
-
-	//Set instance variables to generated maps
-	e.codeMap = codeMap
-	e.frequencyMap = frequencyMap
-
-	//Write code table to output file as first line
-	println("Writing to file...")
-	for k, v := range e.codeMap {
-		_, err := writer.WriteString(fmt.Sprint(k) + " " + v + " ")
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-	_, err = writer.WriteString("\n")
-	if err != nil {
-		log.Fatal(err)
+		return nil
 	}
-	writer.Flush()
-
-	// Track number of bits written to file (not including code table)
-	bodyLength := 0
 
-	// Write encoded body of file to output file
-	for {
-		if c, _, err := reader.ReadRune(); err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				log.Fatal(err)
-			}
-		} else {
-			code := e.codeMap[int(c)]
-			writeEncodedRune(code, bitWriter)
-			bodyLength += len(code)
-		}
+	if e.Alphabet == AlphabetAuto && e.Method != MethodBWT && writer.header.Alphabet == AlphabetBytes {
+		println("Input is not valid UTF-8, compressed one byte at a time.")
 	}
-	// Write pseudo-EOF to end of file
-	code := e.codeMap[pseudoEOF]
-	writeEncodedRune(code, bitWriter)
-	bodyLength += len(code)
-
-	// Flush the writers
-	bitWriter.Flush()
-	writer.Flush()
-
 	println("Compression complete.")
 
 	compressionRatio, err := GetCompressionRatio(inputFileName, compressedFileName)
-
 	if err != nil {
-		println(err.Error())
+		return err
+	}
+	if compressionRatio > 1 {
+		println("WARNING: Compressed file larger than original, possibly due to small input file size")
 	}
 
 	fmt.Printf("File compressed by %.2f%%\n", (1.0-compressionRatio)*100)
-
+	if e.Method == MethodContext {
+		//	Small blocks can take a byte more than with a single table
+		encoded, order0 := writer.ContextGain()
+		if encoded <= order0 {
+			fmt.Printf("Order-1 contexts saved %d bytes (%.2f%%) over a single code table per block\n",
+				order0-encoded, float64(order0-encoded)/float64(order0)*100)
+		} else {
+			fmt.Printf("Order-1 contexts saved nothing over a single code table per block, too little input\n")
+		}
+	}
+	return nil
 }
 
 /* GetCompressionRatio(): Compares the sizes of the original and the compressed
 * files, returns the ratio as a float64.
  */
 func GetCompressionRatio(originalFileName, compressedFileName string) (float64, error) {
-	oFile, err := os.Open(originalFileName)
-	if err != nil {
-		log.Fatal(err)
-	}
-	oFileInfo, err := oFile.Stat()
+	oFileInfo, err := os.Stat(originalFileName)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	cFile, err := os.Open(compressedFileName)
-	if err != nil {
-		log.Fatal(err)
-	}
-	cFileInfo, err := cFile.Stat()
+	cFileInfo, err := os.Stat(compressedFileName)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
 	oFileSize := oFileInfo.Size()
@@ -173,154 +209,226 @@ func GetCompressionRatio(originalFileName, compressedFileName string) (float64,
 
 	compressionRatio := float64(cFileSize) / float64(oFileSize)
 
-	if compressionRatio > 1 {
-		err = errors.New("ERROR: Compressed file larger than original, possibly due to small input file size")
-	}
-
-	return compressionRatio, err
+	return compressionRatio, nil
 }
 
-/* WriteEncodedRune(): Writes the binary encoding of each rune to the compressed file using
-* the BitWriter.
+/* DecodeToDefaultOutputFile():
+* Wrapper function for Decode(). Allows for decoding without specifying an
+* output file. The output file takes the original file name stored in the header,
+* in the same directory as the input file. If no name was stored, it is based on
+* the name of the input file.
  */
-func writeEncodedRune(code string, bitWriter *BitWriter) {
-	for i := 0; i < len(code); i++ {
-		if code[i] == '1' {
-			bitWriter.WriteBit(true)
-		} else {
-			bitWriter.WriteBit(false)
-		}
+func (e *Encoder) DecodeToDefaultOutputFile(inputFileName string) error {
+	if path.Ext(inputFileName) != ".huff" {
+		return fmt.Errorf("%w: %s", ErrNotHuffFile, inputFileName)
 	}
+	return decode(inputFileName, func(h Header) string {
+		return e.decodedFileName(inputFileName, h)
+	}, e)
 }
 
-/* DecodeToDefaultOutputFile():
-* Wrapper function for Decode(). Allows for decoding without specifying an
-* output file. Creates an output file based on the name for the input file.
+/* DecodedFileName(): Returns the name DecodeToDefaultOutputFile() gives the output
+* for a .huff file, reading the original file name from its header.
  */
-func (e *Encoder) DecodeToDefaultOutputFile(inputFileName string) {
+func (e *Encoder) DecodedFileName(inputFileName string) (string, error) {
+	if path.Ext(inputFileName) != ".huff" {
+		return "", fmt.Errorf("%w: %s", ErrNotHuffFile, inputFileName)
+	}
+	encodedFile, err := os.Open(inputFileName)
+	if err != nil {
+		return "", err
+	}
+	defer encodedFile.Close()
+	h, err := readHeader(NewBitReader(encodedFile))
+	if err != nil {
+		return "", err
+	}
+	return e.decodedFileName(inputFileName, h), nil
+}
 
-	extension := path.Ext(inputFileName)
-	if extension != ".huff" {
-		log.Fatal("Can only decompress .huff files")
+/* decodedFileName(): Returns the stored original file name in the same directory as
+* the input file, or if there is none, a name based on the input file name.
+ */
+func (e *Encoder) decodedFileName(inputFileName string, h Header) string {
+	if !e.NoName && validName(h.Name) {
+		return filepath.Join(filepath.Dir(inputFileName), h.Name)
 	}
+	extension := path.Ext(inputFileName)
 	nameWithoutExtension := inputFileName[:len(inputFileName)-len(extension)]
-	outputFileName := nameWithoutExtension + "_decoded.txt"
-
-	Decode(inputFileName, outputFileName, e)
+	return nameWithoutExtension + "_decoded.txt"
+}
 
+/* validName(): Reports whether a file name read from a header can be used as is,
+* without directories or anything else that could write outside the input's directory.
+ */
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, "/\\\x00") && filepath.Base(name) == name
 }
 
 /* Decode(): Takes an encoded .huff file, decodes and writes decoded text to
 * an output file.
  */
-func Decode(inputFileName, outputFileName string, e *Encoder) {
+func Decode(inputFileName, outputFileName string, e *Encoder) error {
+	return decode(inputFileName, func(Header) string { return outputFileName }, e)
+}
+
+/* decode(): Decodes a .huff file to the output file named by outputFileName() once
+* the header has been read.
+ */
+func decode(inputFileName string, outputFileName func(h Header) string, e *Encoder) (err error) {
+	//	Remove input file on success if asked to, after it has been closed. The
+	//	output was checked against its checksums while it was decoded.
+	defer func() {
+		if err == nil && e.RemoveInput {
+			err = os.Remove(inputFileName)
+		}
+	}()
+
 	//	Open encoded file
 	encodedFile, err := os.Open(inputFileName)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	//	Close inputFile on exit & check for its returned error
-	defer func() {
-		if err := encodedFile.Close(); err != nil {
-			panic(err)
-		}
-	}()
+	//	Close inputFile on exit
+	defer encodedFile.Close()
 
-	//	Open output file
-	decodedFile, err := os.Create(outputFileName)
+	//	Create Reader. Creating the Reader parses the header at the top of the encoded
+	//	file, so a file that is not a .huff file is rejected before any output is created.
+	reader, err := e.newReader(encodedFile)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	//	Close outputFile on exit
-	defer func() {
-		if err := decodedFile.Close(); err != nil {
-			panic(err)
-		}
-	}()
 
-	//	Create Reader & Writer
-	reader := bufio.NewReader(encodedFile)
-	bitReader := NewBitReader(reader)
+	//	Open output file under a temporary name, removed on exit unless committed.
+	//	Output that decoded in full gets the original file's permissions and
+	//	modification time.
+	decodedFile, err := createOutputFile(encodedFile, outputFileName(reader.Header), e.Overwrite)
+	if err != nil {
+		return err
+	}
+	defer decodedFile.Abort()
 	writer := bufio.NewWriter(decodedFile)
 
-	//	Generate Code Map from printed code table on encoded file
-	println("Generating code map...")
-	e.codeMap = make(map[int]string)
-	scanner := bufio.NewScanner(encodedFile)
-	scanner.Scan()
-	frequencyMapString := scanner.Text()
-	words := strings.Fields(frequencyMapString)
-
-	var last string
-	for i, word := range words {
-		var k, v string
-		if i%2 == 1 {
-			k = last
-			v = word
-			intK, err := strconv.Atoi(k)
-			if err != nil {
-				log.Fatal(err)
+	//	Decode the body of the file and write the decoded text to the output file,
+	//	then give it its real name. Output that failed to decode is never given its
+	//	real name, unless asked to keep whatever could be decoded.
+	e.println("Decoding file...")
+	if err := copyDecoded(writer, reader); err != nil {
+		if e.SkipCorruptBlocks {
+			if cerr := decodedFile.Commit(); cerr != nil {
+				return cerr
 			}
-			e.codeMap[intK] = v
 		}
-		last = word
+		return err
+	}
+	if !e.NoName {
+		decodedFile.restore = &reader.Header
 	}
+	if err := decodedFile.Commit(); err != nil {
+		return err
+	}
+	e.println("Decoding complete.")
+	return nil
+}
 
-	//	Exit if code map (and thus the encoded file) is empty
-	if len(e.codeMap) == 0 {
-		println("Compressed file is empty.")
-		println("Decompression complete.")
-		return
+/* restoreFileInfo(): Gives the named file the permissions and modification time
+* recorded in h, if any.
+ */
+func restoreFileInfo(name string, h Header) error {
+	if h.Mode != 0 {
+		if err := os.Chmod(name, h.Mode); err != nil {
+			return err
+		}
+	}
+	if !h.ModTime.IsZero() {
+		if err := os.Chtimes(name, h.ModTime, h.ModTime); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	//	Create Reversed Code Map for reverse lookups
-	e.reverseCodeMap = reverseMap(e.codeMap)
+/* EncodeStream(): Compresses everything read from r and writes it to w, reading r only
+* once so it can be a pipe. inputInfo describes the file r reads from, for storing
+* its name, permissions and modification time, or is nil if there is no such file.
+ */
+func (e *Encoder) EncodeStream(w io.Writer, r io.Reader, inputInfo fs.FileInfo) error {
+	writer := e.newWriter(w, inputInfo)
+	if _, err := io.Copy(writer, r); err != nil {
+		return err
+	}
+	return writer.Close()
+}
 
-	//	Reset file cursor to beginning of file
-	_, err = encodedFile.Seek(0, io.SeekStart)
+/* DecodeStream(): Decompresses the stream read from r and writes the result to w. */
+func (e *Encoder) DecodeStream(w io.Writer, r io.Reader) error {
+	reader, err := e.newReader(r)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	writer := bufio.NewWriter(w)
+	return copyDecoded(writer, reader)
+}
 
-	//	Skip code table printed at top of encoded file
-	reader.ReadString('\n')
-
-	//	Read encoded file one bit at a time until a sequence of bits matches a code
-	//	in the code table. Once it does, write the corresponding rune to the
-	//	decoded .txt file and start again. This is done until EOF is reached.
-	println("Decoding file...")
-	var code string
-	for {
+/* newWriter(): Returns a Writer to w with the Encoder's options, storing the name,
+* permissions and modification time from inputInfo unless it is nil or NoName is set.
+ */
+func (e *Encoder) newWriter(w io.Writer, inputInfo fs.FileInfo) *Writer {
+	writer := NewWriter(w)
+	writer.Options = e.Options
+	if inputInfo != nil && !e.NoName {
+		writer.Name = inputInfo.Name()
+		writer.Mode = inputInfo.Mode().Perm()
+		writer.ModTime = inputInfo.ModTime()
+	}
+	return writer
+}
 
-		if b, err := bitReader.ReadBit(); err != nil {
-			if err != io.EOF {
-				log.Fatal(err)
-			}
-		} else {
-			if b {
-				code = code + "1"
-			} else {
-				code = code + "0"
-			}
-		}
-		if asciiVal, exists := e.reverseCodeMap[code]; exists {
-			if asciiVal == pseudoEOF {
-				break
-			}
-			writer.WriteString(string(rune(asciiVal)))
-			code = ""
-			writer.Flush()
-		}
+/* newReader(): Returns a Reader for r with the Encoder's decoding settings. */
+func (e *Encoder) newReader(r io.Reader) (*Reader, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	reader.SkipCorruptBlocks = e.SkipCorruptBlocks
+	reader.Threads = e.Threads
+	reader.Dictionary = e.Dictionary
+	return reader, nil
+}
 
+/* copyDecoded(): Copies everything decoded by reader to writer and flushes it.
+* Returns ErrCorruptInput if any blocks were skipped.
+ */
+func copyDecoded(writer *bufio.Writer, reader *Reader) error {
+	if _, err := io.Copy(writer, reader); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
 	}
-	println("Decoding complete.")
+	if reader.SkippedBlocks > 0 {
+		return fmt.Errorf("%w: skipped %d corrupt blocks", ErrCorruptInput, reader.SkippedBlocks)
+	}
+	return nil
 }
 
-/* reverseMap(): Takes a map, returns the same map but in reverse. */
-func reverseMap(m map[int]string) map[string]int {
-	n := make(map[string]int, len(m))
-	for k, v := range m {
-		n[v] = k
+/* Verify(): Decodes a .huff file without writing the output anywhere, checking its
+* header, code tables and checksums. Returns nil if the file is intact.
+ */
+func Verify(inputFileName string, e *Encoder) error {
+	encodedFile, err := os.Open(inputFileName)
+	if err != nil {
+		return err
+	}
+	defer encodedFile.Close()
+
+	reader, err := NewReader(encodedFile)
+	if err != nil {
+		return err
 	}
-	return n
+	reader.Threads = e.Threads
+	reader.Dictionary = e.Dictionary
+	_, err = io.Copy(io.Discard, reader)
+	return err
 }
@@ -0,0 +1,705 @@
+package huffmyfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// testText returns n bytes of log-like text, generated the same way every time
+func testText(n int) []byte {
+	words := strings.Fields("GET POST /index.html /api/v1/users 200 404 500 ms user=alice " +
+		"user=bob INFO WARN ERROR request served in äöü ✓ — connection reset by peer")
+	rng := rand.New(rand.NewSource(1))
+
+	var buf bytes.Buffer
+	for buf.Len() < n {
+		buf.WriteString(words[rng.Intn(len(words))])
+		if rng.Intn(8) == 0 {
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.Bytes()[:n]
+}
+
+func compress(t testing.TB, data []byte, options Options) []byte {
+	var buf bytes.Buffer
+	zw := NewWriter(&buf)
+	zw.Options = options
+	if _, err := zw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decompress(t testing.TB, compressed []byte) []byte {
+	zr, err := NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRoundTripBlocks(t *testing.T) {
+	// Input spanning several blocks, written a few bytes at a time so runes are split
+	// between writes
+	data := testText(DefaultBlockSize*2 + 12345)
+	var buf bytes.Buffer
+	zw := NewWriter(&buf)
+	for p := data; len(p) > 0; {
+		n := 1 + len(p)%7000
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := zw.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := decompress(t, buf.Bytes()); !bytes.Equal(got, data) {
+		t.Errorf("Decoded data not equal to input.")
+	}
+}
+
+func TestWriterOptions(t *testing.T) {
+	// Options that cannot be used are rejected even if nothing is written, without
+	// writing anything
+	for i, options := range []Options{
+		{BlockSize: -5},
+		{Level: 99},
+		{Method: MethodBWT, Coding: CodingAdaptive},
+		{BlockSize: -5, Level: 99, Method: MethodBWT, Coding: CodingAdaptive},
+	} {
+		var buf bytes.Buffer
+		zw := NewWriter(&buf)
+		zw.Options = options
+		if err := zw.Close(); err == nil || buf.Len() != 0 {
+			t.Errorf("Test Case %d failed. Expected an error and no output for %+v, got %v and %d bytes", i+1, options, err, buf.Len())
+		}
+		if _, err := zw.Write([]byte("a")); err == nil {
+			t.Errorf("Test Case %d failed. Expected the error to be returned again", i+1)
+		}
+	}
+
+	// Empty input is still written with options that can be used
+	zw := NewWriter(io.Discard)
+	zw.Options = Options{Method: MethodBWT, BlockSize: 1000}
+	if err := zw.Close(); err != nil {
+		t.Errorf("Test Case 5 failed. Expected no error, got %v", err)
+	}
+}
+
+func TestSkipCorruptBlocks(t *testing.T) {
+	data := testText(10000)
+	compressed := compress(t, data, Options{BlockSize: 1000})
+
+	// Walk past the header and two blocks, then zero the length of the pseudo-EOF's
+	// code in the third block's code table
+	offset := len(magic) + 4
+	for i := 0; i < 2; i++ {
+		_, n := binary.Uvarint(compressed[offset:])
+		encodedSize, m := binary.Uvarint(compressed[offset+n:])
+		offset += n + m + int(encodedSize)
+	}
+	_, n := binary.Uvarint(compressed[offset:])
+	_, m := binary.Uvarint(compressed[offset+n:])
+	compressed[offset+n+m] = 0
+
+	zr, err := NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(zr); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("Test Case 1 failed. Expected ErrCorruptInput, got %v", err)
+	}
+
+	zr, err = NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr.SkipCorruptBlocks = true
+	got, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.SkippedBlocks != 1 || !bytes.Equal(got, append(data[:2000:2000], data[3000:]...)) {
+		t.Errorf("Test Case 2 failed. Expected only the third block to be skipped.")
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	data := testText(10000)
+	for i, options := range []Options{{BlockSize: 1000}, {BlockSize: 1000, BlockChecksums: true}} {
+		// Flip a bit of the checksum of the whole input, then of the last block's
+		// checksum, which comes right before the end block
+		compressed := compress(t, data, options)
+		offsets := []int{len(compressed) - 1}
+		if options.BlockChecksums {
+			offsets = append(offsets, len(compressed)-checksumSize-2)
+		}
+		for _, offset := range offsets {
+			corrupt := append([]byte(nil), compressed...)
+			corrupt[offset] ^= 1
+
+			zr, err := NewReader(bytes.NewReader(corrupt))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadAll(zr); !errors.Is(err, ErrChecksum) {
+				t.Errorf("Test Case %d failed. Expected ErrChecksum at offset %d, got %v", i+1, offset, err)
+			}
+		}
+	}
+}
+
+func TestHeaderFileInfo(t *testing.T) {
+	modTime := time.Date(2023, 9, 1, 12, 30, 0, 123456789, time.UTC)
+	var buf bytes.Buffer
+	zw := NewWriter(&buf)
+	zw.Name, zw.Mode, zw.ModTime = "notes.txt", 0640, modTime
+	zw.Write([]byte("ABRACADABRA"))
+	zw.Close()
+
+	zr, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.Name != "notes.txt" || zr.Mode != 0640 || !zr.ModTime.Equal(modTime) {
+		t.Errorf("Expected notes.txt, 0640 and %v in the header, got %q, %#o and %v",
+			modTime, zr.Name, zr.Mode, zr.ModTime)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	// Blocks are cut the same way however many are compressed at once, so the output
+	// must not depend on the number of threads
+	data := testText(200000)
+	serial := compress(t, data, Options{BlockSize: 10000, Threads: 1})
+	parallel := compress(t, data, Options{BlockSize: 10000, Threads: 8})
+	if !bytes.Equal(serial, parallel) {
+		t.Fatalf("Test Case 1 failed. Output depends on the number of threads.")
+	}
+
+	for i, threads := range []int{1, 3, 8} {
+		zr, err := NewReader(bytes.NewReader(parallel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr.Threads = threads
+		got, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Test Case %d failed. Decoded data not equal to input.", i+2)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	binary := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(binary)
+
+	testCases := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("ABRACADABRA\nalakazam\n! : åßˆ\n\n"),
+		[]byte("invalid \xff\xfe UTF-8 \xed\xb3\xbf and a real \ufffd"),
+		testText(100000),
+		binary,
+	}
+	for _, alphabet := range []Alphabet{AlphabetAuto, AlphabetRunes, AlphabetBytes} {
+		for i, data := range testCases {
+			got := decompress(t, compress(t, data, Options{Alphabet: alphabet, BlockSize: 30000}))
+			if !bytes.Equal(got, data) {
+				t.Errorf("Test Case %d (%v) failed. Decoded data not equal to input.", i+1, alphabet)
+			}
+		}
+	}
+}
+
+func TestArchive(t *testing.T) {
+	entries := []struct {
+		name string
+		data []byte
+	}{
+		{"a.txt", testText(1000)},
+		{"dir/empty", nil},
+		{"dir/sub/b.log", testText(DefaultBlockSize + 5)},
+	}
+	modTime := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	aw := NewArchiveWriter(&buf)
+	for _, e := range entries {
+		w, err := aw.Create(ArchiveEntry{Name: e.name, Mode: 0640, ModTime: modTime})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := aw.Create(ArchiveEntry{Name: "../escape"}); err == nil {
+		t.Errorf("Test Case 1 failed. Expected an error for a name outside the archive.")
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the entries in reverse, so each is found through the directory alone
+	archive := buf.Bytes()
+	ar, err := NewArchiveReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ar.Entries) != len(entries) {
+		t.Fatalf("Test Case 2 failed. Expected %d entries, got %d", len(entries), len(ar.Entries))
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := ar.Entries[i]
+		if entry.Name != entries[i].name || entry.Size != int64(len(entries[i].data)) ||
+			entry.Mode != 0640 || !entry.ModTime.Equal(modTime) {
+			t.Errorf("Test Case 3 failed. Unexpected entry %+v", entry)
+		}
+		r, err := ar.Open(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, entries[i].data) {
+			t.Errorf("Test Case 4 failed. %s not equal to input: %v", entry.Name, err)
+		}
+	}
+
+	// A corrupt entry only affects reading that entry
+	corrupt := append([]byte(nil), archive...)
+	corrupt[ar.Entries[0].offset+ar.Entries[0].CompressedSize-1] ^= 1
+	ar, err = NewArchiveReader(bytes.NewReader(corrupt), int64(len(corrupt)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err := ar.Open(ar.Entries[0]); err == nil {
+		if _, err := io.ReadAll(r); !errors.Is(err, ErrChecksum) {
+			t.Errorf("Test Case 5 failed. Expected a checksum error, got %v", err)
+		}
+	}
+	if r, err := ar.Open(ar.Entries[2]); err != nil {
+		t.Fatal(err)
+	} else if _, err := io.ReadAll(r); err != nil {
+		t.Errorf("Test Case 5 failed. Unexpected error %v", err)
+	}
+
+	// A truncated archive has no directory
+	if _, err := NewArchiveReader(bytes.NewReader(archive[:len(archive)-1]), int64(len(archive)-1)); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("Test Case 6 failed. Expected a corrupt input error, got %v", err)
+	}
+	if _, err := NewArchiveReader(bytes.NewReader(compress(t, nil, Options{})), 20); !errors.Is(err, ErrNotArchive) {
+		t.Errorf("Test Case 6 failed. Expected a not archive error, got %v", err)
+	}
+}
+
+func TestLengthLimitedCodes(t *testing.T) {
+	// Fibonacci frequencies give the deepest possible Huffman tree
+	freqMap := map[int]int{}
+	a, b := 1, 1
+	for k := 0; k < 30; k++ {
+		freqMap[k] = a
+		a, b = b, a+b
+	}
+	tree := HuffTree{}
+	tree.MakeHuffmanTree(freqMap)
+	if tree.MaxCodeLength() != 29 {
+		t.Errorf("Test Case 1 failed. Expected a tree 29 deep, got %d", tree.MaxCodeLength())
+	}
+	for _, limit := range []int{5, 8, 12} {
+		if err := tree.MakeLengthLimitedTree(freqMap, limit); err != nil {
+			t.Fatal(err)
+		}
+		lengthMap := tree.CodeLengths()
+		if tree.MaxCodeLength() != limit || checkCodeLengths(lengthMap) != nil {
+			t.Errorf("Test Case 2 failed. Invalid lengths %v for a limit of %d", lengthMap, limit)
+		}
+	}
+	if err := tree.MakeLengthLimitedTree(freqMap, 4); err == nil {
+		t.Errorf("Test Case 3 failed. Expected an error coding 30 symbols in 4 bits.")
+	}
+
+	// The limit is only applied when needed, and otherwise the code stays optimal
+	freqMap = map[int]int{'a': 5, 'b': 2, 'c': 1, 'd': 1}
+	tree.MakeLengthLimitedTree(freqMap, 2)
+	if got := codedBits(freqMap, tree.CodeLengths()); got != 18 {
+		t.Errorf("Test Case 4 failed. Expected 18 bits, got %d", got)
+	}
+
+	// Skewed input round trips with the limit recorded in the header
+	var data []byte
+	for k := 0; k < 20; k++ {
+		data = append(data, bytes.Repeat([]byte{'a' + byte(k)}, 1<<k/64+1)...)
+	}
+	compressed := compress(t, data, Options{MaxCodeLength: 11})
+	zr, err := NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.MaxCodeLength != 11 {
+		t.Errorf("Test Case 5 failed. Expected a limit of 11 in the header, got %d", zr.MaxCodeLength)
+	}
+	if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Test Case 5 failed. Decoded data not equal to input: %v", err)
+	}
+}
+
+func TestDictionary(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 100; i++ {
+		samples = append(samples, []byte(`{"id":`+strings.Repeat("7", i%5+1)+`,"event":"login","ok":true}`))
+	}
+	dict, err := TrainDictionary(samples, AlphabetAuto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := dict.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	stored := append([]byte(nil), buf.Bytes()...)
+	if dict, err = ReadDictionary(&buf); err != nil {
+		t.Fatal(err)
+	}
+	stored[len(stored)-1] ^= 1
+	if _, err := ReadDictionary(bytes.NewReader(stored)); !errors.Is(err, ErrChecksum) && !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("Test Case 1 failed. Expected a corrupt dictionary to be rejected, got %v", err)
+	}
+
+	// A small message is smaller with the dictionary, and one with symbols the
+	// dictionary lacks still round trips with its own table
+	message := []byte(`{"id":12,"event":"logout","ok":false}`)
+	plain := compress(t, message, Options{})
+	withDict := compress(t, message, Options{Dictionary: dict})
+	if len(withDict) >= len(plain) {
+		t.Errorf("Test Case 2 failed. Expected the dictionary to help, got %d and %d bytes", len(withDict), len(plain))
+	}
+	for _, data := range [][]byte{message, []byte("ünïcödé ✓"), testText(DefaultBlockSize + 100)} {
+		zr, err := NewReader(bytes.NewReader(compress(t, data, Options{Dictionary: dict})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr.Dictionary = dict
+		if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Test Case 3 failed. Decoded data not equal to input: %v", err)
+		}
+	}
+
+	// Without the dictionary, or with another one, decoding fails
+	other, _ := TrainDictionary([][]byte{[]byte("something else")}, AlphabetRunes)
+	for _, d := range []*Dictionary{nil, other} {
+		zr, err := NewReader(bytes.NewReader(withDict))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr.Dictionary = d
+		if _, err := io.ReadAll(zr); !errors.Is(err, ErrDictionary) {
+			t.Errorf("Test Case 4 failed. Expected a dictionary error, got %v", err)
+		}
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	inputs := [][]byte{
+		{},
+		[]byte("a"),
+		[]byte("ünïcödé ✓ abracadabra"),
+		{0xff, 0xfe, 0x00, 0xff, 'x'},
+		testText(2*DefaultBlockSize + 100),
+	}
+	for i, data := range inputs {
+		compressed := compress(t, data, Options{Coding: CodingAdaptive, BlockChecksums: true})
+		zr, err := NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !zr.Adaptive {
+			t.Errorf("Test Case 1 failed. Input %d: expected adaptive coding in the header", i)
+		}
+		if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Test Case 1 failed. Input %d: decoded data not equal to input: %v", i, err)
+		}
+	}
+
+	// Without stored tables, a small message is smaller than with static coding
+	message := []byte(`{"id":12,"event":"logout","ok":false}`)
+	if adaptive, static := compress(t, message, Options{Coding: CodingAdaptive}), compress(t, message, Options{}); len(adaptive) >= len(static) {
+		t.Errorf("Test Case 2 failed. Expected %d adaptive bytes to be fewer than %d", len(adaptive), len(static))
+	}
+
+	// Adaptive coding has no code table to limit or share
+	for _, options := range []Options{{Coding: CodingAdaptive, MaxCodeLength: 12}, {Coding: CodingAdaptive, Dictionary: &Dictionary{}}} {
+		zw := NewWriter(io.Discard)
+		zw.Options = options
+		if _, err := zw.Write(message); err == nil {
+			if err = zw.Close(); err == nil {
+				t.Errorf("Test Case 3 failed. Expected an error for %+v", options)
+			}
+		}
+	}
+}
+
+func TestLZ77(t *testing.T) {
+	// Every value survives being split into a bucket code and extra bits
+	for v := 0; v < MaxWindow; v += v/7 + 1 {
+		code, extra, extraBits := lzBucket(v)
+		base, n := lzBucketBase(code)
+		if n != extraBits || base+int(extra) != v || extra >= 1<<n {
+			t.Fatalf("Test Case 1 failed. %d coded as %d, %d, %d bits", v, code, extra, extraBits)
+		}
+	}
+
+	var log []byte
+	for i := 0; i < 5000; i++ {
+		log = append(log, fmt.Sprintf("2023-06-01T12:%02d:%02d INFO request id=%d path=/api/v1/items status=200 ✓\n", i/60%60, i%60, i*7919%1000)...)
+	}
+	mixed := append(bytes.Repeat([]byte{0, 1, 2, 0xff, 0xfe}, 1000), []byte("ünïcödé ✓ ünïcödé ✓")...)
+	inputs := [][]byte{{}, []byte("a"), []byte("abcabcabcabcabc"), []byte("ünïcödé ✓ ünïcödé ✓ \xff ünïcödé"), mixed, log}
+	for _, level := range []int{1, 6, MaxLevel} {
+		for i, data := range inputs {
+			compressed := compress(t, data, Options{Level: level, Window: MinWindow * 4, BlockSize: 64 << 10, BlockChecksums: true})
+			zr, err := NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if zr.Level != level || zr.Window != MinWindow*4 {
+				t.Errorf("Test Case 2 failed. Input %d: expected level %d in the header, got %d", i, level, zr.Level)
+			}
+			if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("Test Case 2 failed. Level %d, input %d: decoded data not equal to input: %v", level, i, err)
+			}
+		}
+	}
+
+	// Repetitive input compresses far better than with Huffman coding alone
+	plain, lz := compress(t, log, Options{}), compress(t, log, Options{Level: 6})
+	if len(lz)*3 > len(plain) {
+		t.Errorf("Test Case 3 failed. Expected LZ77 to take less than a third of %d bytes, got %d", len(plain), len(lz))
+	}
+
+	// Invalid settings and combinations are rejected
+	for _, options := range []Options{
+		{Level: MaxLevel + 1}, {Level: 1, Window: MaxWindow + 1}, {Level: 1, Coding: CodingAdaptive}, {Level: 1, Dictionary: &Dictionary{}},
+	} {
+		zw := NewWriter(io.Discard)
+		zw.Options = options
+		if _, err := zw.Write(log); err == nil {
+			t.Errorf("Test Case 4 failed. Expected an error for %+v", options)
+		}
+	}
+
+	// Distances beyond the window, or before the start of the block, are corrupt
+	var buf bytes.Buffer
+	writeDistanceLengths(&buf, map[int]int{40: 1})
+	if _, err := readDistanceLengths(&buf, MinWindow); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("Test Case 5 failed. Expected a distance code beyond the window to be rejected, got %v", err)
+	}
+	buf.Reset()
+	writeCodeLengths(&buf, map[int]int{lzLengthBase(AlphabetBytes): 1, pseudoEOF: 1})
+	writeDistanceLengths(&buf, map[int]int{3: 1})
+	bitWriter := NewBitWriter(&buf)
+	bitWriter.WriteBits(0b001, 3) // Length 3, distance 4, end of the block
+	bitWriter.Flush()
+	h := &Header{Alphabet: AlphabetBytes, Level: 1, Window: MinWindow}
+	if _, err := decodeBlock(buf.Bytes(), 3, h, nil); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("Test Case 5 failed. Expected a match before the start of the block to be rejected, got %v", err)
+	}
+}
+
+func TestBWT(t *testing.T) {
+	transformed, primary := BWT([]byte("banana"))
+	if string(transformed) != "annbaa" || primary != 4 {
+		t.Errorf("Test Case 1 failed. Expected annbaa and 4, got %s and %d", transformed, primary)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	r.Read(random)
+	inputs := [][]byte{{}, []byte("a"), bytes.Repeat([]byte("ab"), 1000), bytes.Repeat([]byte{0}, 70000), random, testText(300000)}
+	for i, data := range inputs {
+		transformed, primary := BWT(data)
+		if got, err := InverseBWT(transformed, primary); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Test Case 2 failed. Input %d: inverse transform not equal to input: %v", i, err)
+		}
+		if got := moveToFront(moveToFront(data, false), true); !bytes.Equal(got, data) {
+			t.Errorf("Test Case 2 failed. Input %d: inverse move-to-front not equal to input", i)
+		}
+
+		compressed := compress(t, data, Options{Method: MethodBWT, BlockSize: 100000, BlockChecksums: true})
+		zr, err := NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !zr.BWT || zr.Alphabet != AlphabetBytes {
+			t.Errorf("Test Case 3 failed. Input %d: expected BWT and bytes in the header", i)
+		}
+		if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Test Case 3 failed. Input %d: decoded data not equal to input: %v", i, err)
+		}
+	}
+
+	// Text compresses better than with Huffman coding alone
+	text := testText(1 << 20)
+	if bwt, plain := compress(t, text, Options{Method: MethodBWT}), compress(t, text, Options{}); len(bwt)*2 > len(plain) {
+		t.Errorf("Test Case 4 failed. Expected BWT to take less than half of %d bytes, got %d", len(plain), len(bwt))
+	}
+
+	if _, err := InverseBWT([]byte("annbaa"), 7); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("Test Case 5 failed. Expected an invalid primary index to be rejected, got %v", err)
+	}
+
+	// A tiny block holding a single run of zeros as long as the huge size it claims is
+	// rejected without allocating that size
+	lengthMap := map[int]int{bwtRunA: 1, bwtRunB: 2, pseudoEOF: 2}
+	codeMap := canonicalCodes(lengthMap)
+	var block bytes.Buffer
+	writeUvarint(&block, 0)
+	writeCodeLengths(&block, lengthMap)
+	bw := NewBitWriter(&block)
+	for n := MaxBlockSize + 1; n > 1; n >>= 1 {
+		code := codeMap[n&1]
+		bw.WriteBits(code.bits, code.length)
+	}
+	bw.WriteBits(codeMap[pseudoEOF].bits, codeMap[pseudoEOF].length)
+	bw.Flush()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := decodeBlock(block.Bytes(), MaxBlockSize, &Header{Alphabet: AlphabetBytes, BWT: true}, nil)
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; !errors.Is(err, ErrCorruptInput) || allocated > 1<<20 {
+		t.Errorf("Test Case 6 failed. Expected a %d byte block claiming %d bytes to be rejected, got %v after allocating %d bytes",
+			block.Len(), MaxBlockSize, err, allocated)
+	}
+
+	for _, options := range []Options{{Method: MethodBWT, Alphabet: AlphabetRunes}, {Method: MethodBWT, Level: 1},
+		{Method: MethodBWT, BlockSize: MaxBWTBlockSize + 1}} {
+		zw := NewWriter(io.Discard)
+		zw.Options = options
+		if _, err := zw.Write(text); err == nil {
+			t.Errorf("Test Case 5 failed. Expected an error for %+v", options)
+		}
+	}
+}
+
+func TestContexts(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	r.Read(random)
+	runes := []byte(strings.Repeat("Καλημέρα κόσμε, こんにちは世界! ", 500))
+	inputs := [][]byte{{}, []byte("a"), bytes.Repeat([]byte("ab"), 1000), random, runes, testText(300000)}
+	for i, data := range inputs {
+		for _, options := range []Options{
+			{Method: MethodContext, BlockSize: 100000, BlockChecksums: true},
+			{Method: MethodContext, Alphabet: AlphabetBytes, MaxCodeLength: 12},
+		} {
+			compressed := compress(t, data, options)
+			zr, err := NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !zr.Contexts {
+				t.Errorf("Test Case 1 failed. Input %d: expected context modeling in the header", i)
+			}
+			if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+				t.Errorf("Test Case 1 failed. Input %d: decoded data not equal to input: %v", i, err)
+			}
+		}
+	}
+
+	// Text compresses better than with a single code table, and Inspect() finds the
+	// tables the contexts were merged into
+	text := testText(1 << 20)
+	zw := NewWriter(io.Discard)
+	zw.Method = MethodContext
+	if _, err := zw.Write(text); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	encoded, order0 := zw.ContextGain()
+	if plain := compress(t, text, Options{}); encoded >= order0 || int(encoded) >= len(plain) {
+		t.Errorf("Test Case 2 failed. Expected fewer than %d bytes, got %d", order0, encoded)
+	}
+	info, err := Inspect(bytes.NewReader(compress(t, text, Options{Method: MethodContext})), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := info.Blocks[0].ContextTables
+	if len(tables) < 2 || len(tables) > maxContextTables || info.Blocks[0].Symbols() != utf8.RuneCount(text) {
+		t.Errorf("Test Case 3 failed. Expected 2 to %d code tables for %d symbols, got %d for %d",
+			maxContextTables, utf8.RuneCount(text), len(tables), info.Blocks[0].Symbols())
+	}
+
+	// The same input always gives the same output, whatever order maps are read in
+	first := compress(t, text[:100000], Options{Method: MethodContext})
+	for i := 0; i < 5; i++ {
+		if again := compress(t, text[:100000], Options{Method: MethodContext}); !bytes.Equal(again, first) {
+			t.Errorf("Test Case 4 failed. Compressing the same input again gave different output")
+			break
+		}
+	}
+
+	// A context map pointing past the last table is rejected
+	h := &Header{Alphabet: AlphabetBytes, Contexts: true}
+	if _, err := decodeBlock([]byte{2, 1, 0, 2}, 1, h, nil); !errors.Is(err, ErrCorruptInput) {
+		t.Errorf("Test Case 5 failed. Expected an invalid context map to be rejected, got %v", err)
+	}
+	for _, options := range []Options{{Method: MethodContext, Coding: CodingAdaptive}, {Method: MethodContext, Level: 1}} {
+		zw := NewWriter(io.Discard)
+		zw.Options = options
+		if _, err := zw.Write(text); err == nil {
+			t.Errorf("Test Case 6 failed. Expected an error for %+v", options)
+		}
+	}
+}
+
+func BenchmarkWriter(b *testing.B) {
+	data := testText(4 << 20)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		compress(b, data, Options{})
+	}
+}
+
+func BenchmarkReader(b *testing.B) {
+	data := testText(4 << 20)
+	compressed := compress(b, data, Options{})
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zr, err := NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.Copy(io.Discard, zr); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package huffmyfile
+
+import "errors"
+
+// Errors returned by the package. Errors may be wrapped with additional context, so
+// callers should match them using errors.Is().
+var (
+	// ErrCorruptInput is returned when a compressed stream is truncated or its
+	// contents do not decode to valid data.
+	ErrCorruptInput = errors.New("huffmyfile: corrupt input")
+
+	// ErrChecksum is returned when a compressed stream decodes, but the result does
+	// not match the checksum stored with it.
+	ErrChecksum = errors.New("huffmyfile: checksum mismatch")
+
+	// ErrEmptyCodeTable is returned when a compressed stream has a body but no codes
+	// to decode it with.
+	ErrEmptyCodeTable = errors.New("huffmyfile: empty code table")
+
+	// ErrNotHuffFile is returned when asked to decompress a file which is not a .huff
+	// file, either because of its extension or because it does not start with the
+	// .huff magic number.
+	ErrNotHuffFile = errors.New("huffmyfile: not a .huff file")
+
+	// ErrNotArchive is returned when asked to read a file which does not start with
+	// the .huffa archive magic number.
+	ErrNotArchive = errors.New("huffmyfile: not a .huffa archive")
+
+	// ErrNotDictionary is returned when asked to read a dictionary from a file which
+	// does not start with the dictionary magic number.
+	ErrNotDictionary = errors.New("huffmyfile: not a dictionary file")
+
+	// ErrDictionary is returned when decompressing a stream that uses a dictionary
+	// without the dictionary it was compressed with.
+	ErrDictionary = errors.New("huffmyfile: missing or wrong dictionary")
+
+	// ErrUnsupportedVersion is returned when a .huff file was written using a format
+	// version or feature that this version of the package cannot read.
+	ErrUnsupportedVersion = errors.New("huffmyfile: unsupported format version")
+)
@@ -12,49 +12,22 @@ https://opensource.org/licenses/MIT.
 
 package huffmyfile
 
-import (
-	"bufio"
-	"io"
-	"log"
-	"os"
-)
-
 //	Note: a pseudoEOF is used to indicate the end of the encoded file. MaxInt is used
 //	to represent this pseudoEOF as it would never be used to represent a different character.
 const pseudoEOF = int(^uint(0) >> 1) // MaxInt
 
-/* makeFrequencyMap(): Reads the input file and counts the frequency of each character.
-* Returns a map of each character and their respective frequency in the file.
+/* countSymbols(): Splits data into symbols of the given alphabet and counts the
+* frequency of each. A pseudo-EOF is added to the map unless data is empty.
  */
-func makeFrequencyMap(infile string) map[int]int {
+func countSymbols(data []byte, alphabet Alphabet) map[int]int {
 	m := make(map[int]int)
 
-	//Open input file
-	inf, err := os.Open(infile)
-	if err != nil {
-		log.Fatal(err)
-	}
-	//close inf on exit & check for its returned error
-	defer func() {
-		if err := inf.Close(); err != nil {
-			panic(err)
-		}
-	}()
-
-	//Create Reader
-	r := bufio.NewReader(inf)
-	//Read through input file one rune at a time
-	for {
-		if c, _, err := r.ReadRune(); err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				log.Fatal(err)
-			}
-		} else {
-			//Adds 1 to value of c in map
-			m[int(c)] += 1
-		}
+	//Read through input one symbol at a time
+	for len(data) > 0 {
+		c, size := alphabet.nextSymbol(data)
+		data = data[size:]
+		//Adds 1 to value of c in map
+		m[c] += 1
 	}
 
 	//Add a pseudo-EOF character to aid in decompression (MaxInt)
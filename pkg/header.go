@@ -0,0 +1,348 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Reads and writes the header at the start of every .huff file. All multi-byte
+* integers are unsigned varints as written by encoding/binary.
+*
+*	magic           4 bytes  "HUFF"
+*	version         1 byte   formatVersion
+*	flags           1 byte   see flag* constants
+*	alphabet        1 byte   what the symbols in the code table represent
+*	table encoding  1 byte   how the code table is stored
+*	original size   varint   only present if flagSize is set
+*	name length     varint   only present if flagName is set
+*	name                     original file name, without directories
+*	mode            varint   permission bits of the original file, only if flagMode is set
+*	modification    varint   modification time of the original file in nanoseconds since
+*	time                     the Unix epoch, signed, only present if flagModTime is set
+*	max code length 1 byte   longest code any block may use, only if flagMaxCodeLength is set
+*	level           1 byte   LZ77 compression level, only present if flagLZ77 is set
+*	window          varint   furthest back an LZ77 match may refer to, only if flagLZ77 is set
+*	dictionary ID   4 bytes  big-endian ID of the dictionary the blocks may use, only
+*	                         present if the table encoding is tableDictionary
+*
+* The header is followed by the compressed blocks, see block.go.
+ */
+
+package huffmyfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+const (
+	magic         = "HUFF"
+	formatVersion = 1
+)
+
+// Header flags
+//
+// Compatibility: formatVersion stays 1 as long as every change to the format is
+// gated by a new flag or a new table encoding. A reader rejects flags outside
+// knownFlags and table encodings it does not know with ErrUnsupportedVersion, so an
+// older reader fails on a newer file before reading any blocks, rather than
+// misreading it. Every flag bit is now taken, so a change that cannot be gated by a
+// table encoding must bump formatVersion, as must any change to how files that set
+// only existing flags and encodings are read.
+const (
+	flagSize           = 1 << iota // The original size is stored in the header
+	flagBlockChecksums             // Every block ends with a checksum of its input
+	flagName                       // The original file name is stored in the header
+	flagMode                       // The original file's permission bits are stored in the header
+	flagModTime                    // The original file's modification time is stored in the header
+	flagMaxCodeLength              // Codes are limited to a length stored in the header
+	flagAdaptive                   // Blocks are coded with adaptive Huffman codes, see adaptive.go
+	flagLZ77                       // Blocks are made up of LZ77 literals and matches, see lz77.go
+
+	knownFlags = flagSize | flagBlockChecksums | flagName | flagMode | flagModTime | flagMaxCodeLength |
+		flagAdaptive | flagLZ77
+)
+
+// Longest original file name that can be stored
+const maxNameLength = 1024
+
+// Symbol alphabets, as stored in the header
+const (
+	alphabetRunes = 0 // Symbols are Unicode code points of UTF-8 encoded text
+	alphabetBytes = 1 // Symbols are single bytes
+)
+
+// Code table encodings
+const (
+	tableCanonical  = 1 // Only code lengths are stored, see canonical.go
+	tableDictionary = 2 // Each block either stores its code lengths or uses a dictionary, see block.go
+	tableBWT        = 3 // Each block stores a BWT primary index and its code lengths, see bwt.go
+	tableContext    = 4 // Each block stores a code table for each group of contexts, see context.go
+)
+
+/* Header: Information stored at the start of a .huff file. */
+type Header struct {
+	Version  uint8    // Format version of the file
+	Size     int64    // Size of the original input in bytes, -1 if it was not recorded
+	Alphabet Alphabet // What the symbols in the code table represent
+
+	BlockChecksums bool        // Every block stores a checksum of its input
+	Name           string      // Original file name without directories, empty if it was not recorded
+	Mode           fs.FileMode // Permission bits of the original file, 0 if they were not recorded
+	ModTime        time.Time   // Modification time of the original file, zero if it was not recorded
+	MaxCodeLength  int         // Longest code in any block's code table in bits, 0 if not limited
+	Adaptive       bool        // Blocks are coded with adaptive codes and store no code tables
+	Level          int         // LZ77 compression level the blocks were compressed at, 0 if LZ77 was not used
+	Window         int         // Furthest back in bytes an LZ77 match may refer to, 0 if LZ77 was not used
+	BWT            bool        // Blocks went through the Burrows-Wheeler transform, see bwt.go
+	Contexts       bool        // Symbols are coded with a table chosen by the symbol before them, see context.go
+	DictionaryID   uint32      // ID of the Dictionary needed to decode the file, 0 if none is
+}
+
+/* writeHeader(): Writes h to w, starting with the magic number. */
+func writeHeader(w *bufio.Writer, h *Header) error {
+	var flags uint8
+	if h.Size >= 0 {
+		flags |= flagSize
+	}
+	if h.BlockChecksums {
+		flags |= flagBlockChecksums
+	}
+	if h.Name != "" {
+		if len(h.Name) > maxNameLength {
+			return fmt.Errorf("huffmyfile: file name is longer than %d bytes", maxNameLength)
+		}
+		flags |= flagName
+	}
+	if h.Mode&fs.ModePerm != 0 {
+		flags |= flagMode
+	}
+	if !h.ModTime.IsZero() {
+		flags |= flagModTime
+	}
+	if h.MaxCodeLength != 0 {
+		flags |= flagMaxCodeLength
+	}
+	if h.Adaptive {
+		flags |= flagAdaptive
+	}
+	if h.Level != 0 {
+		flags |= flagLZ77
+	}
+	tableEncoding := uint8(tableCanonical)
+	if h.DictionaryID != 0 {
+		tableEncoding = tableDictionary
+	} else if h.BWT {
+		tableEncoding = tableBWT
+	} else if h.Contexts {
+		tableEncoding = tableContext
+	}
+
+	w.WriteString(magic)
+	w.Write([]byte{formatVersion, flags, alphabetByte(h.Alphabet), tableEncoding})
+	if flags&flagSize != 0 {
+		writeUvarint(w, uint64(h.Size))
+	}
+	if flags&flagName != 0 {
+		writeUvarint(w, uint64(len(h.Name)))
+		w.WriteString(h.Name)
+	}
+	if flags&flagMode != 0 {
+		writeUvarint(w, uint64(h.Mode&fs.ModePerm))
+	}
+	if flags&flagModTime != 0 {
+		buf := make([]byte, binary.MaxVarintLen64)
+		w.Write(buf[:binary.PutVarint(buf, h.ModTime.UnixNano())])
+	}
+	if flags&flagMaxCodeLength != 0 {
+		w.WriteByte(uint8(h.MaxCodeLength))
+	}
+	if flags&flagLZ77 != 0 {
+		w.WriteByte(uint8(h.Level))
+		writeUvarint(w, uint64(h.Window))
+	}
+	if tableEncoding == tableDictionary {
+		binary.Write(w, binary.BigEndian, h.DictionaryID)
+	}
+	// bufio.Writer errors are sticky, so checking the last write is enough
+	_, err := w.Write(nil)
+	return err
+}
+
+/* readHeader(): Reads and validates the header at the start of r. */
+func readHeader(r io.ByteReader) (h Header, err error) {
+	buf := make([]byte, len(magic)+4)
+	for i := range buf {
+		if buf[i], err = r.ReadByte(); err != nil {
+			if err == io.EOF {
+				return h, ErrNotHuffFile
+			}
+			return h, err
+		}
+	}
+	if string(buf[:len(magic)]) != magic {
+		return h, ErrNotHuffFile
+	}
+
+	h.Version = buf[len(magic)]
+	flags := buf[len(magic)+1]
+	tableEncoding := buf[len(magic)+3]
+	if h.Version != formatVersion {
+		return h, fmt.Errorf("%w %d", ErrUnsupportedVersion, h.Version)
+	}
+	if flags&^knownFlags != 0 {
+		return h, fmt.Errorf("%w: unknown flags %#x", ErrUnsupportedVersion, flags&^knownFlags)
+	}
+	if h.Alphabet, err = parseAlphabetByte(buf[len(magic)+2]); err != nil {
+		return h, err
+	}
+	if tableEncoding < tableCanonical || tableEncoding > tableContext {
+		return h, fmt.Errorf("%w: unknown code table encoding %d", ErrUnsupportedVersion, tableEncoding)
+	}
+	h.BWT = tableEncoding == tableBWT
+	if h.BWT && flags&(flagAdaptive|flagLZ77) != 0 {
+		return h, fmt.Errorf("%w: BWT cannot be combined with adaptive coding or LZ77", ErrCorruptInput)
+	}
+	h.Contexts = tableEncoding == tableContext
+	if h.Contexts && flags&(flagAdaptive|flagLZ77) != 0 {
+		return h, fmt.Errorf("%w: context modeling cannot be combined with adaptive coding or LZ77", ErrCorruptInput)
+	}
+
+	h.BlockChecksums = flags&flagBlockChecksums != 0
+	h.Adaptive = flags&flagAdaptive != 0
+	h.Size = -1
+	if flags&flagSize != 0 {
+		size, err := readUvarint(r)
+		if err != nil {
+			return h, err
+		}
+		h.Size = int64(size)
+		if h.Size < 0 {
+			return h, fmt.Errorf("%w: invalid original size", ErrCorruptInput)
+		}
+	}
+	if flags&flagName != 0 {
+		n, err := readUvarint(r)
+		if err != nil {
+			return h, err
+		}
+		if n == 0 || n > maxNameLength {
+			return h, fmt.Errorf("%w: invalid file name length %d", ErrCorruptInput, n)
+		}
+		name := make([]byte, n)
+		for i := range name {
+			if name[i], err = r.ReadByte(); err != nil {
+				return h, truncated(err)
+			}
+		}
+		h.Name = string(name)
+	}
+	if flags&flagMode != 0 {
+		mode, err := readUvarint(r)
+		if err != nil {
+			return h, err
+		}
+		if mode == 0 || mode&^uint64(fs.ModePerm) != 0 {
+			return h, fmt.Errorf("%w: invalid file mode %#o", ErrCorruptInput, mode)
+		}
+		h.Mode = fs.FileMode(mode)
+	}
+	if flags&flagModTime != 0 {
+		nsec, err := binary.ReadVarint(r)
+		if err != nil {
+			return h, truncated(err)
+		}
+		h.ModTime = time.Unix(0, nsec)
+	}
+	if flags&flagMaxCodeLength != 0 {
+		length, err := r.ReadByte()
+		if err != nil {
+			return h, truncated(err)
+		}
+		if length < 1 || length > maxCodeLength {
+			return h, fmt.Errorf("%w: invalid code length limit %d", ErrCorruptInput, length)
+		}
+		h.MaxCodeLength = int(length)
+	}
+	if flags&flagLZ77 != 0 {
+		level, err := r.ReadByte()
+		if err != nil {
+			return h, truncated(err)
+		}
+		if level < 1 || level > MaxLevel {
+			return h, fmt.Errorf("%w: invalid compression level %d", ErrCorruptInput, level)
+		}
+		h.Level = int(level)
+		window, err := readUvarint(r)
+		if err != nil {
+			return h, err
+		}
+		if window < MinWindow || window > MaxWindow {
+			return h, fmt.Errorf("%w: invalid window size %d", ErrCorruptInput, window)
+		}
+		h.Window = int(window)
+		if h.Adaptive || tableEncoding == tableDictionary {
+			return h, fmt.Errorf("%w: LZ77 cannot be combined with adaptive coding or a dictionary", ErrCorruptInput)
+		}
+	}
+	if tableEncoding == tableDictionary {
+		var id [4]byte
+		for i := range id {
+			if id[i], err = r.ReadByte(); err != nil {
+				return h, truncated(err)
+			}
+		}
+		h.DictionaryID = binary.BigEndian.Uint32(id[:])
+		if h.DictionaryID == 0 {
+			return h, fmt.Errorf("%w: invalid dictionary ID", ErrCorruptInput)
+		}
+	}
+	return h, nil
+}
+
+/* alphabetByte(): Returns how an alphabet other than AlphabetAuto is stored. */
+func alphabetByte(a Alphabet) uint8 {
+	if a == AlphabetBytes {
+		return alphabetBytes
+	}
+	return alphabetRunes
+}
+
+/* parseAlphabetByte(): Returns the alphabet stored as b. */
+func parseAlphabetByte(b uint8) (Alphabet, error) {
+	switch b {
+	case alphabetRunes:
+		return AlphabetRunes, nil
+	case alphabetBytes:
+		return AlphabetBytes, nil
+	}
+	return AlphabetAuto, fmt.Errorf("%w: unknown alphabet %d", ErrUnsupportedVersion, b)
+}
+
+func writeUvarint(w byteWriter, x uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	w.Write(buf[:binary.PutUvarint(buf, x)])
+}
+
+func readUvarint(r io.ByteReader) (uint64, error) {
+	x, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, truncated(err)
+	}
+	return x, nil
+}
+
+/* truncated(): Reports an unexpected end of input as corrupt input. */
+func truncated(err error) error {
+	if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: unexpected end of input", ErrCorruptInput)
+	}
+	return err
+}
@@ -26,8 +26,8 @@ type PriorityQueue []*HTWrapper
 func (pq PriorityQueue) Len() int { return len(pq) }
 
 func (pq PriorityQueue) Less(i, j int) bool {
-	// We want Pop to give us the highest, not lowest, priority so we use greater than here.
-	return pq[i].ht.root.freq > pq[j].ht.root.freq
+	// We want Pop to give us the tree with the lowest total frequency, so we use less than here.
+	return pq[i].ht.root.freq < pq[j].ht.root.freq
 }
 
 func (pq PriorityQueue) Swap(i, j int) {
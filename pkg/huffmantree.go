@@ -11,6 +11,7 @@ package huffmyfile
 import (
 	"container/heap"
 	"fmt"
+	"sort"
 )
 
 type HuffNode struct {
@@ -26,9 +27,17 @@ type HuffTree struct {
 func (a *HuffTree) MakeHuffmanTree(freqMap map[int]int) {
 	pq := make(PriorityQueue, 0)
 
-	for k, v := range freqMap {
+	//Characters are added in ascending order so the same frequencies always produce
+	//the same tree, regardless of map iteration order.
+	keys := make([]int, 0, len(freqMap))
+	for k := range freqMap {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	for _, k := range keys {
 		//Fills priority queue with individual, single-node huffman trees for each character.
-		r := HuffNode{asciiVal: k, freq: v}
+		r := HuffNode{asciiVal: k, freq: freqMap[k]}
 		h := HuffTree{root: &r}
 		htw := HTWrapper{ht: &h}
 		heap.Push(&pq, &htw)
@@ -37,17 +46,48 @@ func (a *HuffTree) MakeHuffmanTree(freqMap map[int]int) {
 	//Removes two smallest (lowest total frequency) trees, combines them, pushes it back onto queue.
 	//Repeats until there is one large Huffman tree with each character as a leaf.
 	for pq.Len() > 1 {
-		hta := pq.Pop().(*HTWrapper).ht
-		htb := pq.Pop().(*HTWrapper).ht
+		hta := heap.Pop(&pq).(*HTWrapper).ht
+		htb := heap.Pop(&pq).(*HTWrapper).ht
 		htw := HTWrapper{ht: hta.Combine(htb)}
 		heap.Push(&pq, &htw)
 	}
-	heap.Init(&pq)
-
 	a.root = new(HuffNode)
 	*a.root = *pq[0].ht.root
 }
 
+//Builds the Huffman tree for freqMap like MakeHuffmanTree(), but with no code longer than
+//maxLength bits. If the Huffman tree is deeper than that, the tree is rebuilt from the
+//optimal code lengths within the limit, found with the package-merge algorithm.
+func (a *HuffTree) MakeLengthLimitedTree(freqMap map[int]int, maxLength int) error {
+	a.MakeHuffmanTree(freqMap)
+	if a.MaxCodeLength() <= maxLength {
+		return nil
+	}
+	lengthMap, err := limitedCodeLengths(freqMap, maxLength)
+	if err != nil {
+		return err
+	}
+
+	//Each character's leaf is placed at the end of the path spelled by its canonical code
+	a.root = &HuffNode{}
+	for k, c := range canonicalCodes(lengthMap) {
+		n := a.root
+		for i := int(c.length) - 1; i >= 0; i-- {
+			n.freq += freqMap[k]
+			next := &n.left
+			if c.bits>>uint(i)&1 == 1 {
+				next = &n.right
+			}
+			if *next == nil {
+				*next = &HuffNode{}
+			}
+			n = *next
+		}
+		n.asciiVal, n.freq = k, freqMap[k]
+	}
+	return nil
+}
+
 //Returns a new HuffTree with a new root node pointing at the two which were combined.
 //The left child has lower frequency, and the freq value of the root node is the sum of its children.
 func (a *HuffTree) Combine(b *HuffTree) *HuffTree {
@@ -107,6 +147,35 @@ func (r *HuffNode) generateCodes(code string, codeMap map[int]string) {
 
 }
 
+//Returns a map of the length of the code each character will be represented by, which
+//is the depth of its leaf in the tree.
+func (ht *HuffTree) CodeLengths() map[int]int {
+	lm := make(map[int]int)
+
+	ht.root.generateLengths(0, lm)
+	return lm
+}
+
+//Returns the length of the longest code, which is the depth of the tree.
+func (ht *HuffTree) MaxCodeLength() int {
+	return maxLength(ht.CodeLengths())
+}
+
+func (r *HuffNode) generateLengths(depth int, lengthMap map[int]int) {
+
+	if r.left == nil && r.right == nil {
+		lengthMap[r.asciiVal] = depth
+		return
+	}
+	if r.left != nil {
+		r.left.generateLengths(depth+1, lengthMap)
+	}
+	if r.right != nil {
+		r.right.generateLengths(depth+1, lengthMap)
+	}
+
+}
+
 //Prints out a pre-order representation of the HuffmanTree. Characters are printed but
 //only their ascii values are stored in the nodes.
 func (a *HuffTree) Print() {
@@ -0,0 +1,346 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Inspection of compressed streams, for seeing how well the code tables suit the
+* input. Only code lengths are stored, so symbol frequencies are found by decoding.
+ */
+
+package huffmyfile
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"sort"
+)
+
+/* Info: What Inspect() found in a compressed stream. */
+type Info struct {
+	Header
+
+	Blocks []BlockInfo
+}
+
+/* BlockInfo: The sizes and code table of a single block. */
+type BlockInfo struct {
+	Size        int          // Bytes of input in the block
+	EncodedSize int          // Bytes taken up by the block's code table, body and checksum
+	Codes       []SymbolCode // Sorted by code, so shortest first, or by symbol for adaptive blocks
+
+	UsesDictionary bool // The block is coded with the dictionary's code table
+	Adaptive       bool // The block is coded with adaptive codes
+	LZ77           bool // The block is made up of LZ77 literals and matches
+	BWT            bool // The block went through the BWT, and Codes are its run-length coded move-to-front symbols
+
+	//	For blocks coded with order-1 contexts, the code table of each group of
+	//	contexts, with Codes left empty
+	ContextTables []ContextTable
+
+	//	For LZ77 blocks, Codes holds the literal and length codes, and DistanceCodes
+	//	the distance codes, sorted by code
+	DistanceCodes []SymbolCode
+	Matches       int // Number of LZ77 matches
+	MatchedBytes  int // Bytes of input coded as LZ77 matches
+
+	bodyBits int // Bits taken up by the body of an adaptive or LZ77 block
+}
+
+/* ContextTable: A code table shared by some of the contexts of a block. */
+type ContextTable struct {
+	Contexts []string     // The symbols before those coded with the table, start for the first, or #n for hashed runes
+	Codes    []SymbolCode // Sorted by code
+}
+
+/* SymbolCode: A symbol in a block's code table, along with how often it occurs. */
+type SymbolCode struct {
+	Symbol    int    // The symbol as coded, with the end of the block as the largest int
+	Name      string // Quoted with strconv.QuoteRune(), bytes past 0x7F as '\xff', or EOF for the end of the block, and RUNA, RUNB or MTF n in BWT blocks
+	Frequency int    // Number of times the symbol occurs in the block
+	Code      string // The symbol's code as a string of 0s and 1s, empty for adaptive codes
+
+	//	Set for the length and distance codes of LZ77 blocks, whose names give the
+	//	lengths or distances they stand for instead
+	isLength, isDistance bool
+}
+
+/* Inspect(): Decodes the stream read from r and returns its header and the code table
+* of every block. Fails if the stream is corrupt. dict is only needed if the stream
+* was compressed with a dictionary.
+ */
+func Inspect(r io.Reader, dict *Dictionary) (*Info, error) {
+	br := NewBitReader(r)
+	header, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{Header: header}
+
+	var crc uint32
+	var written int64
+	for {
+		size, encoded, err := readBlock(br)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", len(info.Blocks)+1, err)
+		}
+		if size == 0 {
+			break
+		}
+
+		data, err := decodeBlock(encoded, size, &header, dict)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %w", len(info.Blocks)+1, err)
+		}
+		crc = crc32.Update(crc, crcTable, data)
+		written += int64(len(data))
+
+		frequencyMap := countSymbols(data, header.Alphabet)
+		block := BlockInfo{Size: size, EncodedSize: len(encoded), Adaptive: header.Adaptive}
+		if header.Adaptive {
+			block.bodyBits = len(encoded) * 8
+			if header.BlockChecksums {
+				block.bodyBits -= checksumSize * 8
+			}
+			//	Codes change after every symbol, so there is no table to show
+			for k, f := range frequencyMap {
+				block.Codes = append(block.Codes, SymbolCode{Symbol: k, Name: header.Alphabet.quote(k), Frequency: f})
+			}
+			sort.Slice(block.Codes, func(i, j int) bool { return block.Codes[i].Symbol < block.Codes[j].Symbol })
+			info.Blocks = append(info.Blocks, block)
+			continue
+		}
+
+		if header.Level != 0 {
+			//	Decoded again to count the literals and matches, which were already
+			//	checked by decodeBlock()
+			br := NewBitReader(bytes.NewReader(encoded))
+			tables, _ := readLZ77Tables(br, &header)
+			stats := &lzStats{litLen: make(map[int]int), distance: make(map[int]int)}
+			tables.decode(br, size, &header, stats)
+
+			base := lzLengthBase(header.Alphabet)
+			block.LZ77 = true
+			block.Codes = codeList(tables.litLenLengths, stats.litLen, func(k int) string {
+				if k >= base && k != pseudoEOF {
+					return lzCodeName("length", k-base, lzMinMatch)
+				}
+				return header.Alphabet.quote(k)
+			})
+			for i, c := range block.Codes {
+				block.Codes[i].isLength = c.Symbol >= base && !c.IsEnd()
+			}
+			block.DistanceCodes = codeList(tables.distanceLengths, stats.distance, func(k int) string {
+				return lzCodeName("distance", k, 1)
+			})
+			for i := range block.DistanceCodes {
+				block.DistanceCodes[i].isDistance = true
+			}
+			block.bodyBits = stats.extraBits
+			for _, c := range block.Codes {
+				if !c.IsEnd() {
+					block.bodyBits += c.Frequency * len(c.Code)
+				}
+			}
+			for _, c := range block.DistanceCodes {
+				block.bodyBits += c.Frequency * len(c.Code)
+				block.Matches += c.Frequency
+			}
+			block.MatchedBytes = stats.matchedBytes
+			info.Blocks = append(info.Blocks, block)
+			continue
+		}
+
+		if header.BWT {
+			//	Decoded again to count the symbols after move-to-front and run-length
+			//	coding, which were already checked by decodeBlock()
+			counts := make(map[int]int)
+			decodeBWTBlock(NewBitReader(bytes.NewReader(encoded)), size, &header, counts)
+			_, lengthMap, _ := readBWTTable(NewBitReader(bytes.NewReader(encoded)), &header)
+			block.BWT = true
+			block.Codes = codeList(lengthMap, counts, bwtSymbolName)
+			info.Blocks = append(info.Blocks, block)
+			continue
+		}
+
+		if header.Contexts {
+			//	Decoded again to count the symbols coded with each table, which were
+			//	already checked by decodeBlock()
+			br := NewBitReader(bytes.NewReader(encoded))
+			tables, _ := readContextTables(br, &header)
+			counts := make([]map[int]int, len(tables.lengthMaps))
+			for i := range counts {
+				counts[i] = make(map[int]int)
+			}
+			decodeSymbols(br, size, header.Alphabet, tables.decoder(counts))
+			for i, lengthMap := range tables.lengthMaps {
+				table := ContextTable{Codes: codeList(lengthMap, counts[i], header.Alphabet.quote)}
+				for ctx, t := range tables.tableOf {
+					if t == i {
+						table.Contexts = append(table.Contexts, contextName(ctx, header.Alphabet))
+					}
+				}
+				block.ContextTables = append(block.ContextTables, table)
+			}
+			info.Blocks = append(info.Blocks, block)
+			continue
+		}
+
+		//	The table was already checked by decodeBlock()
+		lengthMap, _, _ := readBlockTable(NewBitReader(bytes.NewReader(encoded)), &header, dict)
+		block.UsesDictionary = header.DictionaryID != 0 && encoded[0] == tableFromDictionary
+		block.Codes = codeList(lengthMap, frequencyMap, header.Alphabet.quote)
+		info.Blocks = append(info.Blocks, block)
+	}
+
+	storedCRC, err := readChecksum(br)
+	if err != nil {
+		return nil, fmt.Errorf("end of input: %w", err)
+	}
+	if header.Size >= 0 && written != header.Size {
+		return nil, fmt.Errorf("%w: decoded %d bytes, expected %d", ErrCorruptInput, written, header.Size)
+	}
+	if crc != storedCRC {
+		return nil, fmt.Errorf("%w: decoded input", ErrChecksum)
+	}
+	return info, nil
+}
+
+/* codeList(): Returns the canonical codes for lengthMap with the frequency and name of
+* each symbol, sorted by code.
+ */
+func codeList(lengthMap, frequencyMap map[int]int, name func(symbol int) string) []SymbolCode {
+	var codes []SymbolCode
+	for k, c := range canonicalCodes(lengthMap) {
+		codes = append(codes, SymbolCode{
+			Symbol:    k,
+			Name:      name(k),
+			Frequency: frequencyMap[k],
+			Code:      fmt.Sprintf("%0*b", c.length, c.bits),
+		})
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		ci, cj := codes[i].Code, codes[j].Code
+		if len(ci) != len(cj) {
+			return len(ci) < len(cj)
+		}
+		return ci < cj
+	})
+	return codes
+}
+
+/* IsEnd(): Reports whether c is the code marking the end of the block. */
+func (c SymbolCode) IsEnd() bool {
+	return c.Symbol == pseudoEOF
+}
+
+/* IsLiteral(): Reports whether c codes a symbol of the input, rather than the end of
+* the block or part of an LZ77 match.
+ */
+func (c SymbolCode) IsLiteral() bool {
+	return !c.IsEnd() && !c.isLength && !c.isDistance
+}
+
+/* DecodedSize(): Returns the total size of the input in bytes. */
+func (info *Info) DecodedSize() int64 {
+	var size int64
+	for _, b := range info.Blocks {
+		size += int64(b.Size)
+	}
+	return size
+}
+
+/* Tables(): Returns the codes of each of the block's code tables, which is only Codes
+* unless the block is coded with order-1 contexts.
+ */
+func (b *BlockInfo) Tables() [][]SymbolCode {
+	if len(b.ContextTables) == 0 {
+		return [][]SymbolCode{b.Codes}
+	}
+	var tables [][]SymbolCode
+	for _, t := range b.ContextTables {
+		tables = append(tables, t.Codes)
+	}
+	return tables
+}
+
+/* Symbols(): Returns the number of symbols coded in the block, not counting the end of
+* the block. For LZ77 blocks, each literal and each match counts as one.
+ */
+func (b *BlockInfo) Symbols() int {
+	n := 0
+	for _, codes := range b.Tables() {
+		for _, c := range codes {
+			if c.Symbol != pseudoEOF {
+				n += c.Frequency
+			}
+		}
+	}
+	return n
+}
+
+/* CodedBits(): Returns the number of bits taken up by the block's coded symbols, not
+* counting the end of the block, its code table or padding. Adaptive codes change as
+* they go, so for adaptive blocks this is the size of the whole body. For LZ77 blocks
+* it includes the distance codes and extra bits of every match.
+ */
+func (b *BlockInfo) CodedBits() int {
+	if b.Adaptive || b.LZ77 {
+		return b.bodyBits
+	}
+	n := 0
+	for _, codes := range b.Tables() {
+		for _, c := range codes {
+			if c.Symbol != pseudoEOF {
+				n += c.Frequency * len(c.Code)
+			}
+		}
+	}
+	return n
+}
+
+/* EntropyBits(): Returns the Shannon entropy of the block's symbol frequencies times the
+* number of symbols, which is the fewest bits any code for one symbol at a time could
+* take to code them. For blocks coded with order-1 contexts, the entropy is that of the
+* frequencies in each table, given which table a symbol's context selects.
+ */
+func (b *BlockInfo) EntropyBits() float64 {
+	bits := 0.0
+	for _, codes := range b.Tables() {
+		total := 0.0
+		for _, c := range codes {
+			if c.Symbol != pseudoEOF {
+				total += float64(c.Frequency)
+			}
+		}
+		for _, c := range codes {
+			if c.Symbol != pseudoEOF && c.Frequency > 0 {
+				f := float64(c.Frequency)
+				bits += f * math.Log2(total/f)
+			}
+		}
+	}
+	return bits
+}
+
+/* CodeLengthHistogram(): Returns the number of symbols with codes of each length,
+* indexed by length.
+ */
+func (b *BlockInfo) CodeLengthHistogram() []int {
+	var histogram []int
+	for _, codes := range b.Tables() {
+		for _, c := range codes {
+			for len(histogram) <= len(c.Code) {
+				histogram = append(histogram, 0)
+			}
+			histogram[len(c.Code)]++
+		}
+	}
+	return histogram
+}
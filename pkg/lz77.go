@@ -0,0 +1,492 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* LZ77 front end, used when Options.Level is set. Before Huffman coding, each block is
+* split into literals and matches, which repeat length bytes starting distance bytes
+* back in the same block. Matches are found with hash chains: the positions of every
+* three bytes are chained together by their hash, and the chain for the next three
+* bytes is searched for the longest match within the window. Higher levels follow the
+* chains further, and check whether waiting one symbol gives a longer match.
+*
+* Literals, the pseudo-EOF and match lengths share one code table, and distances have
+* their own, as in Deflate. An LZ77 block replaces the code table of block.go with:
+*
+*	literal/length  see writeCodeLengths(), length codes follow the alphabet's largest
+*	table           symbol
+*	distance table  varint number of distance codes, then a byte with each code's
+*	                length, 0 for codes that are not used
+*	body            literal and length codes, each length code followed by its extra
+*	                bits, a distance code and the distance's extra bits, then the
+*	                pseudo-EOF, padded to a byte
+*
+* A length less lzMinMatch, or a distance less 1, is coded as a bucket code followed by
+* extra bits. Values 0-3 have their own codes, and after that each power of two is
+* split between two codes, with the bits below the top two sent as they are.
+ */
+
+package huffmyfile
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/bits"
+	"unicode/utf8"
+)
+
+// Compression levels and windows
+const (
+	MaxLevel      = 9       // Highest compression level
+	DefaultWindow = 1 << 15 // Window used when Options.Window is 0
+	MinWindow     = 1 << 8  // Smallest window that can be written or read
+	MaxWindow     = 1 << 22 // Largest window that can be written or read
+)
+
+const (
+	lzMinMatch    = 3   // Shortest match, shorter repeats are coded as literals
+	lzMaxMatch    = 258 // Longest match
+	lzLengthCodes = 16  // Number of bucket codes for lengths lzMinMatch to lzMaxMatch
+	lzMinHashBits = 15  // Bits of the hash used to find earlier copies of three bytes,
+	lzMaxHashBits = 20  // growing with the window so that chains stay short
+)
+
+/* lzLevel: How hard the match finder works at a compression level. */
+type lzLevel struct {
+	chain int  // Most earlier positions tried for each match
+	nice  int  // Length of a match that is taken without looking for a longer one
+	lazy  bool // Check whether the next position has a longer match before taking one
+}
+
+var lzLevels = [MaxLevel + 1]lzLevel{
+	1: {chain: 4, nice: 16},
+	2: {chain: 8, nice: 32},
+	3: {chain: 16, nice: 32},
+	4: {chain: 16, nice: 32, lazy: true},
+	5: {chain: 32, nice: 64, lazy: true},
+	6: {chain: 64, nice: 128, lazy: true},
+	7: {chain: 128, nice: lzMaxMatch, lazy: true},
+	8: {chain: 512, nice: lzMaxMatch, lazy: true},
+	9: {chain: 4096, nice: lzMaxMatch, lazy: true},
+}
+
+/* lzToken: A literal symbol, or a match copying length bytes from distance bytes back. */
+type lzToken struct {
+	symbol   int // Only meaningful if length is 0
+	length   int
+	distance int
+}
+
+/* lzLengthBase(): Returns the symbol of the first length code, one past the largest
+* symbol of the alphabet.
+ */
+func lzLengthBase(alphabet Alphabet) int {
+	if alphabet == AlphabetBytes {
+		return 0x100
+	}
+	return utf8.MaxRune + 1
+}
+
+/* lzDistanceCodes(): Returns the number of distance codes needed for a window. */
+func lzDistanceCodes(window int) int {
+	code, _, _ := lzBucket(window - 1)
+	return code + 1
+}
+
+/* lzBucket(): Splits a value into its bucket code and the extra bits that follow it. */
+func lzBucket(v int) (code int, extra uint64, extraBits uint8) {
+	if v < 4 {
+		return v, 0, 0
+	}
+	n := bits.Len(uint(v)) - 1
+	return 2*n + v>>(n-1)&1, uint64(v & (1<<(n-1) - 1)), uint8(n - 1)
+}
+
+/* lzBucketBase(): Returns the smallest value with a bucket code, and the number of
+* extra bits that follow the code.
+ */
+func lzBucketBase(code int) (base int, extraBits uint8) {
+	if code < 4 {
+		return code, 0
+	}
+	n := code / 2
+	return (2 | code&1) << (n - 1), uint8(n - 1)
+}
+
+/* lzHash(): Hashes the first three bytes of b into hashBits bits. */
+func lzHash(b []byte, hashBits uint) uint32 {
+	return (uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])) * 2654435761 >> (32 - hashBits)
+}
+
+/* lzParse(): Splits data into literal symbols and matches at most window bytes back.
+* For runes, matches only end on rune boundaries, so literals are always whole runes.
+ */
+func lzParse(data []byte, alphabet Alphabet, level, window int) []lzToken {
+	settings := lzLevels[level]
+	hashBits := uint(bits.Len(uint(window)))
+	if hashBits < lzMinHashBits {
+		hashBits = lzMinHashBits
+	} else if hashBits > lzMaxHashBits {
+		hashBits = lzMaxHashBits
+	}
+
+	//	Positions are stored plus one, so that 0 ends a chain
+	head := make([]int32, 1<<hashBits)
+	prev := make([]int32, len(data))
+	insert := func(i int) {
+		if i+lzMinMatch <= len(data) {
+			h := lzHash(data[i:], hashBits)
+			prev[i] = head[h]
+			head[h] = int32(i + 1)
+		}
+	}
+	findMatch := func(i int) (length, distance int) {
+		if i+lzMinMatch > len(data) {
+			return 0, 0
+		}
+		longest := len(data) - i
+		if longest > lzMaxMatch {
+			longest = lzMaxMatch
+		}
+		chain := settings.chain
+		for c := int(head[lzHash(data[i:], hashBits)]) - 1; c >= 0 && i-c <= window && chain > 0; c = int(prev[c]) - 1 {
+			chain--
+			//	Only a match longer than the best so far is of interest
+			if data[c+length] != data[i+length] {
+				continue
+			}
+			n := 0
+			for n < longest && data[c+n] == data[i+n] {
+				n++
+			}
+			if n > length {
+				length, distance = n, i-c
+				if n >= settings.nice || n == longest {
+					break
+				}
+			}
+		}
+		if alphabet != AlphabetBytes {
+			for length > 0 && i+length < len(data) && !utf8.RuneStart(data[i+length]) {
+				length--
+			}
+		}
+		if length < lzMinMatch {
+			return 0, 0
+		}
+		return length, distance
+	}
+
+	var tokens []lzToken
+	next, nextLength, nextDistance := -1, 0, 0 // Match already found at position next
+	for i := 0; i < len(data); {
+		length, distance := nextLength, nextDistance
+		if next != i {
+			length, distance = findMatch(i)
+		}
+		insert(i)
+		symbol, size := alphabet.nextSymbol(data[i:])
+
+		//	A match is put off if the next position has a longer one
+		if length > 0 && settings.lazy && length < settings.nice && i+size < len(data) {
+			next = i + size
+			nextLength, nextDistance = findMatch(next)
+			if nextLength > length {
+				length = 0
+			}
+		}
+
+		if length == 0 {
+			tokens = append(tokens, lzToken{symbol: symbol})
+			i += size
+			continue
+		}
+		tokens = append(tokens, lzToken{length: length, distance: distance})
+		for j := i + 1; j < i+length; j++ {
+			insert(j)
+		}
+		i += length
+	}
+	return tokens
+}
+
+/* encodeLZ77Block(): Encodes a block as literals and matches, followed by its checksum
+* if the header asks for block checksums.
+ */
+func encodeLZ77Block(data []byte, h *Header, crc uint32) ([]byte, error) {
+	tokens := lzParse(data, h.Alphabet, h.Level, h.Window)
+	base := lzLengthBase(h.Alphabet)
+
+	litLenFrequencies := map[int]int{pseudoEOF: 1}
+	distanceFrequencies := make(map[int]int)
+	for _, t := range tokens {
+		if t.length == 0 {
+			litLenFrequencies[t.symbol]++
+			continue
+		}
+		code, _, _ := lzBucket(t.length - lzMinMatch)
+		litLenFrequencies[base+code]++
+		code, _, _ = lzBucket(t.distance - 1)
+		distanceFrequencies[code]++
+	}
+
+	limit := h.MaxCodeLength
+	if limit == 0 {
+		limit = maxCodeLength
+	}
+	litLenLengths, err := lzCodeLengths(litLenFrequencies, limit)
+	if err != nil {
+		return nil, err
+	}
+	distanceLengths, err := lzCodeLengths(distanceFrequencies, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(data) / 2)
+	writeCodeLengths(&buf, litLenLengths)
+	writeDistanceLengths(&buf, distanceLengths)
+
+	litLenCodes, distanceCodes := canonicalCodes(litLenLengths), canonicalCodes(distanceLengths)
+	bitWriter := NewBitWriter(&buf)
+	for _, t := range tokens {
+		if t.length == 0 {
+			code := litLenCodes[t.symbol]
+			bitWriter.WriteBits(code.bits, code.length)
+			continue
+		}
+		bucket, extra, extraBits := lzBucket(t.length - lzMinMatch)
+		code := litLenCodes[base+bucket]
+		bitWriter.WriteBits(code.bits, code.length)
+		bitWriter.WriteBits(extra, extraBits)
+
+		bucket, extra, extraBits = lzBucket(t.distance - 1)
+		code = distanceCodes[bucket]
+		bitWriter.WriteBits(code.bits, code.length)
+		bitWriter.WriteBits(extra, extraBits)
+	}
+	code := litLenCodes[pseudoEOF]
+	bitWriter.WriteBits(code.bits, code.length)
+	bitWriter.Flush()
+
+	if h.BlockChecksums {
+		writeChecksum(&buf, crc)
+	}
+	return buf.Bytes(), nil
+}
+
+/* lzCodeLengths(): Returns the code lengths of a Huffman code for frequencyMap. A lone
+* symbol is given a 1-bit code, since a tree with a single leaf has none.
+ */
+func lzCodeLengths(frequencyMap map[int]int, limit int) (map[int]int, error) {
+	lengthMap := make(map[int]int)
+	if len(frequencyMap) == 0 {
+		return lengthMap, nil
+	}
+	huffmanTree := HuffTree{}
+	if err := huffmanTree.MakeLengthLimitedTree(frequencyMap, limit); err != nil {
+		return nil, err
+	}
+	for k, l := range huffmanTree.CodeLengths() {
+		if l == 0 {
+			l = 1
+		}
+		lengthMap[k] = l
+	}
+	return lengthMap, nil
+}
+
+/* writeDistanceLengths(): Writes the distance code table. */
+func writeDistanceLengths(w byteWriter, lengthMap map[int]int) {
+	n := 0
+	for k := range lengthMap {
+		if k >= n {
+			n = k + 1
+		}
+	}
+	writeUvarint(w, uint64(n))
+	for k := 0; k < n; k++ {
+		w.WriteByte(byte(lengthMap[k]))
+	}
+}
+
+/* readDistanceLengths(): Reads a distance code table written by writeDistanceLengths()
+* and checks that it is valid for the window.
+ */
+func readDistanceLengths(r io.ByteReader, window int) (map[int]int, error) {
+	n, err := readUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(lzDistanceCodes(window)) {
+		return nil, fmt.Errorf("%w: too many distance codes for the window", ErrCorruptInput)
+	}
+	lengthMap := make(map[int]int)
+	for k := 0; k < int(n); k++ {
+		length, err := r.ReadByte()
+		if err != nil {
+			return nil, truncated(err)
+		}
+		if length != 0 {
+			lengthMap[k] = int(length)
+		}
+	}
+
+	//	A single distance code has 1 bit, leaving the other 1-bit sequence unused
+	if len(lengthMap) == 1 {
+		for _, l := range lengthMap {
+			if l != 1 {
+				return nil, fmt.Errorf("%w: invalid code length %d", ErrCorruptInput, l)
+			}
+		}
+	} else if err := checkCodeLengths(lengthMap); err != nil {
+		return nil, err
+	}
+	return lengthMap, nil
+}
+
+/* lzTables: The code tables of an LZ77 block. */
+type lzTables struct {
+	litLenLengths   map[int]int
+	distanceLengths map[int]int
+	litLen          *decodeTable
+	distance        *decodeTable
+}
+
+/* lzStats: Counts of the codes and extra bits in an LZ77 block, for Inspect(). */
+type lzStats struct {
+	litLen       map[int]int
+	distance     map[int]int
+	extraBits    int
+	matchedBytes int
+}
+
+/* readLZ77Tables(): Reads and checks the code tables at the start of an LZ77 block. */
+func readLZ77Tables(br *BitReader, h *Header) (*lzTables, error) {
+	litLenLengths, err := readCodeLengths(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(litLenLengths) == 0 {
+		return nil, ErrEmptyCodeTable
+	}
+	base := lzLengthBase(h.Alphabet)
+	for k := range litLenLengths {
+		if !h.Alphabet.validSymbol(k) && (k < base || k >= base+lzLengthCodes) {
+			return nil, fmt.Errorf("%w: invalid symbol %d in code table", ErrCorruptInput, k)
+		}
+	}
+	distanceLengths, err := readDistanceLengths(br, h.Window)
+	if err != nil {
+		return nil, err
+	}
+	if h.MaxCodeLength != 0 {
+		for _, lengthMap := range []map[int]int{litLenLengths, distanceLengths} {
+			if l := maxLength(lengthMap); l > h.MaxCodeLength {
+				return nil, fmt.Errorf("%w: code length %d exceeds the limit of %d", ErrCorruptInput, l, h.MaxCodeLength)
+			}
+		}
+	}
+	return &lzTables{
+		litLenLengths:   litLenLengths,
+		distanceLengths: distanceLengths,
+		litLen:          newDecodeTable(canonicalCodes(litLenLengths)),
+		distance:        newDecodeTable(canonicalCodes(distanceLengths)),
+	}, nil
+}
+
+/* decode(): Decodes literals and matches until the pseudo-EOF, failing if they come to
+* more than size bytes. If stats is not nil, the codes read are counted in it.
+ */
+func (t *lzTables) decode(br *BitReader, size int, h *Header, stats *lzStats) ([]byte, error) {
+	base := lzLengthBase(h.Alphabet)
+	data := make([]byte, 0, size)
+	var buf [utf8.UTFMax]byte
+	for {
+		symbol, err := t.litLen.decode(br)
+		if err != nil {
+			return nil, err
+		}
+		if stats != nil {
+			stats.litLen[symbol]++
+		}
+		if symbol == pseudoEOF {
+			return data, nil
+		}
+		if symbol < base {
+			if len(data) >= size {
+				return nil, fmt.Errorf("%w: block is longer than its recorded size", ErrCorruptInput)
+			}
+			data = append(data, buf[:h.Alphabet.putSymbol(buf[:], symbol)]...)
+			continue
+		}
+
+		length, err := readBucketValue(br, symbol-base, stats)
+		if err != nil {
+			return nil, err
+		}
+		length += lzMinMatch
+		code, err := t.distance.decode(br)
+		if err != nil {
+			return nil, err
+		}
+		if stats != nil {
+			stats.distance[code]++
+			stats.matchedBytes += length
+		}
+		distance, err := readBucketValue(br, code, stats)
+		if err != nil {
+			return nil, err
+		}
+		distance++
+
+		if distance > len(data) || distance > h.Window {
+			return nil, fmt.Errorf("%w: match distance %d is out of range", ErrCorruptInput, distance)
+		}
+		if length > size-len(data) {
+			return nil, fmt.Errorf("%w: block is longer than its recorded size", ErrCorruptInput)
+		}
+		//	Copied a byte at a time, since a match may overlap the bytes it produces
+		start := len(data) - distance
+		for i := 0; i < length; i++ {
+			data = append(data, data[start+i])
+		}
+	}
+}
+
+/* readBucketValue(): Reads the extra bits following a bucket code and returns the
+* value they code.
+ */
+func readBucketValue(br *BitReader, code int, stats *lzStats) (int, error) {
+	value, extraBits := lzBucketBase(code)
+	if extraBits == 0 {
+		return value, nil
+	}
+	extra, available := br.peekBits(extraBits)
+	if available < extraBits {
+		return 0, truncated(br.err)
+	}
+	br.consume(extraBits)
+	if stats != nil {
+		stats.extraBits += int(extraBits)
+	}
+	return value + int(extra), nil
+}
+
+/* lzCodeName(): Returns how Inspect() names a bucket code, with the range of lengths
+* or distances it stands for. offset is the smallest length or distance.
+ */
+func lzCodeName(kind string, code, offset int) string {
+	first, extraBits := lzBucketBase(code)
+	first += offset
+	if extraBits == 0 {
+		return fmt.Sprintf("%s %d", kind, first)
+	}
+	return fmt.Sprintf("%s %d-%d", kind, first, first+1<<extraBits-1)
+}
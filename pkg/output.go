@@ -0,0 +1,126 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Output files are written under a temporary name in the same directory and only
+* renamed into place once they are complete and synced to disk, so a file with the
+* final name is never half-written, even if the process dies part way through.
+ */
+
+package huffmyfile
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+/* OutputFile: A file being written under a temporary name, which only appears under
+* its real name once Commit() is called.
+ */
+type OutputFile struct {
+	*os.File
+
+	name      string  // Name the file is given by Commit()
+	overwrite bool    // Replace an existing file with the same name
+	restore   *Header // File info to restore before renaming, if not nil
+	done      bool
+}
+
+/* CreateOutputFile(): Creates a temporary file in the same directory as name, to be
+* renamed to name by Commit(). Fails with an error matching fs.ErrExist if name
+* already exists, unless overwrite is set.
+ */
+func CreateOutputFile(name string, overwrite bool) (*OutputFile, error) {
+	if !overwrite {
+		if err := checkNotExist(name); err != nil {
+			return nil, err
+		}
+	}
+
+	//	The permissions are those os.Create() would give the file
+	dir, base := filepath.Split(name)
+	for i := 0; ; i++ {
+		tempName := filepath.Join(dir, fmt.Sprintf(".%s.%d.%d.tmp", base, os.Getpid(), i))
+		f, err := os.OpenFile(tempName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil {
+			return &OutputFile{File: f, name: name, overwrite: overwrite}, nil
+		}
+		if !errors.Is(err, fs.ErrExist) || i == 10000 {
+			return nil, err
+		}
+	}
+}
+
+/* createOutputFile(): Like CreateOutputFile(), but also refuses to replace the input
+* file itself.
+ */
+func createOutputFile(inputFile *os.File, name string, overwrite bool) (*OutputFile, error) {
+	if overwrite {
+		outputInfo, err := os.Stat(name)
+		inputInfo, ierr := inputFile.Stat()
+		if err == nil && ierr == nil && os.SameFile(inputInfo, outputInfo) {
+			return nil, fmt.Errorf("huffmyfile: %s is both the input and the output", name)
+		}
+	}
+	return CreateOutputFile(name, overwrite)
+}
+
+/* checkNotExist(): Returns an error matching fs.ErrExist if the named file exists. */
+func checkNotExist(name string) error {
+	if _, err := os.Lstat(name); err == nil {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+	return nil
+}
+
+/* Commit(): Syncs and closes the file, then renames it to its real name. */
+func (f *OutputFile) Commit() (err error) {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	defer func() {
+		if err != nil {
+			os.Remove(f.File.Name())
+		}
+	}()
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if f.restore != nil {
+		if err := restoreFileInfo(f.File.Name(), *f.restore); err != nil {
+			return err
+		}
+	}
+
+	//	Checked again in case the file was created while this one was being written
+	if !f.overwrite {
+		if err := checkNotExist(f.name); err != nil {
+			return err
+		}
+	}
+	return os.Rename(f.File.Name(), f.name)
+}
+
+/* Abort(): Closes and removes the file, unless it has already been committed. */
+func (f *OutputFile) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.Close()
+	os.Remove(f.File.Name())
+}
@@ -0,0 +1,101 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+/*
+* Length-limited Huffman codes, found with the package-merge algorithm of Larmore and
+* Hirschberg. Each symbol starts out as a coin whose value is its frequency, once for
+* every allowed code length. Going from the longest length to the shortest, the
+* cheapest coins are paired up into packages, which are merged with the coins of the
+* next length. The 2n-2 cheapest items of the last list make up the optimal code: a
+* symbol's code length is the number of them it takes part in.
+ */
+
+package huffmyfile
+
+import (
+	"fmt"
+	"sort"
+)
+
+/* pmItem: A coin for a single symbol, or a package of two cheaper items. */
+type pmItem struct {
+	weight      int64
+	symbol      int // Only meaningful if left is nil
+	left, right *pmItem
+}
+
+/* limitedCodeLengths(): Returns the lengths of an optimal prefix code for the symbols
+* in freqMap in which no code is longer than maxLength bits.
+ */
+func limitedCodeLengths(freqMap map[int]int, maxLength int) (map[int]int, error) {
+	n := len(freqMap)
+	if maxLength < 1 || maxLength > maxCodeLength {
+		return nil, fmt.Errorf("huffmyfile: code length limit must be between 1 and %d bits", maxCodeLength)
+	}
+	if maxLength < 63 && n > 1<<maxLength {
+		return nil, fmt.Errorf("huffmyfile: %d symbols cannot be coded in at most %d bits", n, maxLength)
+	}
+
+	lengthMap := make(map[int]int, n)
+	if n == 1 {
+		for k := range freqMap {
+			lengthMap[k] = 1
+		}
+		return lengthMap, nil
+	}
+
+	//	Symbols are ordered by frequency, then by value, so the result does not depend
+	//	on map iteration order
+	leaves := make([]*pmItem, 0, n)
+	for k, f := range freqMap {
+		leaves = append(leaves, &pmItem{weight: int64(f), symbol: k})
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		if leaves[i].weight != leaves[j].weight {
+			return leaves[i].weight < leaves[j].weight
+		}
+		return leaves[i].symbol < leaves[j].symbol
+	})
+
+	list := leaves
+	for length := maxLength; length > 1; length-- {
+		packages := make([]*pmItem, 0, len(list)/2)
+		for i := 0; i+1 < len(list); i += 2 {
+			packages = append(packages, &pmItem{weight: list[i].weight + list[i+1].weight, left: list[i], right: list[i+1]})
+		}
+
+		//	Merge the packages with a fresh set of coins, coins first on ties
+		merged := make([]*pmItem, 0, len(leaves)+len(packages))
+		i, j := 0, 0
+		for i < len(leaves) || j < len(packages) {
+			if j == len(packages) || (i < len(leaves) && leaves[i].weight <= packages[j].weight) {
+				merged = append(merged, leaves[i])
+				i++
+			} else {
+				merged = append(merged, packages[j])
+				j++
+			}
+		}
+		list = merged
+	}
+
+	for _, item := range list[:2*n-2] {
+		item.countLengths(lengthMap)
+	}
+	return lengthMap, nil
+}
+
+/* countLengths(): Adds one to the code length of every symbol in the item. */
+func (item *pmItem) countLengths(lengthMap map[int]int) {
+	if item.left == nil {
+		lengthMap[item.symbol]++
+		return
+	}
+	item.left.countLengths(lengthMap)
+	item.right.countLengths(lengthMap)
+}
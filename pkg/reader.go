@@ -0,0 +1,177 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package huffmyfile
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+)
+
+/* Reader: An io.Reader that decompresses a Huffman-encoded stream read from an
+* underlying io.Reader, one block at a time. Since every block is prefixed with its
+* length, the Reader reads ahead and decodes up to Threads blocks at once on separate
+* goroutines, returning their contents in order.
+ */
+type Reader struct {
+	Header // Valid after NewReader() returns
+
+	//	If set, blocks that fail to decode are left out of the output instead of
+	//	stopping with an error, and counted in SkippedBlocks. Must be set before the
+	//	first call to Read().
+	SkipCorruptBlocks bool
+	SkippedBlocks     int
+
+	//	Number of blocks decoded at once, runtime.GOMAXPROCS(0) if 0. Must be set
+	//	before the first call to Read().
+	Threads int
+
+	//	Dictionary the stream was compressed with, if its header has a DictionaryID.
+	//	Must be set before the first call to Read().
+	Dictionary *Dictionary
+
+	bitReader *BitReader
+	pending   []chan decodedBlock // Blocks being decoded, in order
+	block     []byte              // Decoded bytes of the current block not yet returned by Read()
+	blocks    int                 // Number of blocks read so far
+	written   int64               // Number of decoded bytes so far
+	crc       uint32              // Checksum of the decoded bytes so far
+	storedCRC uint32              // Checksum of the whole input, read after the last block
+	readAll   bool                // The end of the blocks has been read, or reading failed
+	readErr   error               // Error reading the block after the pending ones
+	done      bool
+	err       error
+}
+
+/* decodedBlock: The result of decoding a block on another goroutine. */
+type decodedBlock struct {
+	index int
+	data  []byte
+	err   error
+}
+
+/* NewReader(): Returns a new Reader decompressing r. The header at the start of the
+* stream is read immediately, so NewReader returns an error if it is malformed.
+ */
+func NewReader(r io.Reader) (z *Reader, err error) {
+	z = &Reader{}
+	z.bitReader = NewBitReader(r)
+
+	z.Header, err = readHeader(z.bitReader)
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+/* Read(): Copies decoded bytes into p, decoding the next block when the current one
+* has been used up.
+ */
+func (z *Reader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(z.block) > 0 {
+			c := copy(p[n:], z.block)
+			z.block = z.block[c:]
+			n += c
+			continue
+		}
+		if z.err != nil {
+			return n, z.err
+		}
+		if z.done {
+			return n, io.EOF
+		}
+		z.err = z.nextBlock()
+	}
+	return n, nil
+}
+
+/* nextBlock(): Starts decoding blocks until Threads of them are in progress, then
+* waits for the oldest. Checks the total size once the end is reached.
+ */
+func (z *Reader) nextBlock() error {
+	if z.blocks == 0 && !z.readAll && z.DictionaryID != 0 {
+		if z.Dictionary == nil {
+			return fmt.Errorf("%w: the input needs dictionary %08x", ErrDictionary, z.DictionaryID)
+		}
+		if z.Dictionary.ID != z.DictionaryID {
+			return fmt.Errorf("%w: the input needs dictionary %08x, not %08x", ErrDictionary, z.DictionaryID, z.Dictionary.ID)
+		}
+	}
+
+	threads := z.Threads
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	for !z.readAll && len(z.pending) < threads {
+		z.readBlock()
+	}
+
+	if len(z.pending) == 0 {
+		if z.readErr != nil {
+			return z.readErr
+		}
+		z.done = true
+		if z.SkippedBlocks > 0 {
+			return nil
+		}
+		if z.Size >= 0 && z.written != z.Size {
+			return fmt.Errorf("%w: decoded %d bytes, expected %d", ErrCorruptInput, z.written, z.Size)
+		}
+		if z.crc != z.storedCRC {
+			return fmt.Errorf("%w: decoded input", ErrChecksum)
+		}
+		return nil
+	}
+	b := <-z.pending[0]
+	z.pending = z.pending[1:]
+	if b.err != nil {
+		if z.SkipCorruptBlocks && (errors.Is(b.err, ErrCorruptInput) || errors.Is(b.err, ErrChecksum) ||
+			errors.Is(b.err, ErrEmptyCodeTable)) {
+			z.SkippedBlocks++
+			return nil
+		}
+		return fmt.Errorf("block %d: %w", b.index, b.err)
+	}
+	z.block = b.data
+	z.written += int64(len(b.data))
+	z.crc = crc32.Update(z.crc, crcTable, b.data)
+	return nil
+}
+
+/* readBlock(): Reads the next block and starts decoding it. A block that cannot
+* be read is not skipped even with SkipCorruptBlocks set, since the blocks after it
+* cannot be found, so its error is returned once the pending blocks have been used up.
+ */
+func (z *Reader) readBlock() {
+	size, encoded, err := readBlock(z.bitReader)
+	if err != nil {
+		z.readAll = true
+		z.readErr = fmt.Errorf("block %d: %w", z.blocks+1, err)
+		return
+	}
+	if size == 0 {
+		z.readAll = true
+		if z.storedCRC, err = readChecksum(z.bitReader); err != nil {
+			z.readErr = fmt.Errorf("end of input: %w", err)
+		}
+		return
+	}
+	z.blocks++
+
+	index := z.blocks
+	result := make(chan decodedBlock, 1)
+	go func() {
+		data, err := decodeBlock(encoded, size, &z.Header, z.Dictionary)
+		result <- decodedBlock{index: index, data: data, err: err}
+	}()
+	z.pending = append(z.pending, result)
+}
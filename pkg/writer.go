@@ -0,0 +1,283 @@
+/*
+Copyright © 2023 Martin Coder <martincoder1@gmail.com>
+
+Use of this source code is governed by an MIT-style
+license that can be found in the LICENSE file or at
+https://opensource.org/licenses/MIT.
+*/
+
+package huffmyfile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"runtime"
+	"time"
+)
+
+/* Writer: An io.WriteCloser that Huffman-compresses everything written to it and
+* writes the result to an underlying io.Writer. Input is buffered in memory until a
+* block's worth has been written, which is then compressed with its own code table,
+* so input is only ever read once and memory use does not grow with its size. Up to
+* Options.Threads blocks are compressed at once on separate goroutines, and written
+* out in order as they finish.
+ */
+type Writer struct {
+	Options // Must be set before the first call to Write()
+
+	//	Original file name without directories, permission bits and modification time
+	//	to store in the header, if set. Must be set before the first call to Write().
+	Name    string
+	Mode    fs.FileMode
+	ModTime time.Time
+
+	w           *bufio.Writer // Underlying writer
+	block       []byte        // Input waiting to be compressed as the next block
+	pending     []chan encodedBlock
+	wroteHeader bool
+	closed      bool
+	err         error
+
+	blockSize int    // Bytes of input per block, 0 until the options are validated
+	header    Header // Written before the first block
+	size      int64  // Number of bytes of input so far
+	crc       uint32 // Checksum of the input so far
+
+	//	Bytes taken up by the blocks written so far, and with MethodContext, the bytes
+	//	they would have taken with a single code table each
+	encodedSize, order0Size int64
+}
+
+/* encodedBlock: The result of compressing a block on another goroutine. */
+type encodedBlock struct {
+	size       int
+	encoded    []byte
+	order0Size int // See encodeBlock(), only set for context blocks
+	err        error
+}
+
+/* NewWriter(): Returns a new Writer. Writes to the returned Writer are compressed
+* and written to w. It is the caller's responsibility to call Close() on the Writer
+* when done.
+ */
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+/* Write(): Buffers p, compressing and writing out each block as it fills up. */
+func (z *Writer) Write(p []byte) (n int, err error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if z.closed {
+		return 0, errors.New("huffmyfile: write to closed Writer")
+	}
+
+	if z.blockSize == 0 {
+		if z.err = z.validate(); z.err != nil {
+			return 0, z.err
+		}
+	}
+
+	for len(p) > 0 {
+		if z.block == nil {
+			z.block = make([]byte, 0, z.blockSize)
+		}
+		c := copy(z.block[len(z.block):z.blockSize], p)
+		z.crc = crc32.Update(z.crc, crcTable, p[:c])
+		z.block = z.block[:len(z.block)+c]
+		p = p[c:]
+		n += c
+		z.size += int64(c)
+
+		if len(z.block) == z.blockSize {
+			if z.err = z.flushBlock(false); z.err != nil {
+				return n, z.err
+			}
+		}
+	}
+	return n, nil
+}
+
+/* validate(): Checks the Options and works out the block size, once before the first
+* call to Write() or Close(), so that even empty input is not written with options that
+* cannot be combined.
+ */
+func (z *Writer) validate() error {
+	blockSize := z.BlockSize
+	if blockSize == 0 {
+		blockSize = DefaultBlockSize
+	}
+	if blockSize < 0 || blockSize > MaxBlockSize {
+		return fmt.Errorf("huffmyfile: block size must be between 1 and %d bytes", MaxBlockSize)
+	}
+	if z.MaxCodeLength < 0 || z.MaxCodeLength > maxCodeLength {
+		return fmt.Errorf("huffmyfile: code length limit must be between 1 and %d bits", maxCodeLength)
+	}
+	if z.Coding == CodingAdaptive && (z.MaxCodeLength != 0 || z.Dictionary != nil) {
+		return errors.New("huffmyfile: adaptive mode cannot limit code lengths or use a dictionary")
+	}
+	if z.Level < 0 || z.Level > MaxLevel {
+		return fmt.Errorf("huffmyfile: compression level must be between 0 and %d", MaxLevel)
+	}
+	if z.Window != 0 && (z.Window < MinWindow || z.Window > MaxWindow) {
+		return fmt.Errorf("huffmyfile: window must be between %d and %d bytes", MinWindow, MaxWindow)
+	}
+	if z.Level != 0 && (z.Coding == CodingAdaptive || z.Dictionary != nil) {
+		return errors.New("huffmyfile: LZ77 cannot be combined with adaptive mode or a dictionary")
+	}
+	if z.Method == MethodBWT && (z.Coding == CodingAdaptive || z.Dictionary != nil || z.Level != 0 || z.Alphabet == AlphabetRunes) {
+		return errors.New("huffmyfile: BWT works on bytes, and cannot be combined with adaptive mode, a dictionary or LZ77")
+	}
+	if z.Method == MethodBWT && blockSize > MaxBWTBlockSize {
+		return fmt.Errorf("huffmyfile: BWT block size must be at most %d bytes", MaxBWTBlockSize)
+	}
+	if z.Method == MethodContext && (z.Coding == CodingAdaptive || z.Dictionary != nil || z.Level != 0) {
+		return errors.New("huffmyfile: context modeling cannot be combined with adaptive mode, a dictionary or LZ77")
+	}
+	if z.Dictionary != nil && z.Alphabet != AlphabetAuto && z.Alphabet != z.Dictionary.Alphabet {
+		return fmt.Errorf("huffmyfile: the dictionary's alphabet is %v, not %v", z.Dictionary.Alphabet, z.Alphabet)
+	}
+	z.blockSize = blockSize
+	return nil
+}
+
+/* Close(): Compresses any remaining input and writes the end of the stream. Close does
+* not close the underlying writer.
+ */
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if z.blockSize == 0 {
+		if z.err = z.validate(); z.err != nil {
+			return z.err
+		}
+	}
+	if z.err = z.flushBlock(true); z.err != nil {
+		return z.err
+	}
+	if z.err = z.writePending(0); z.err != nil {
+		return z.err
+	}
+	if z.err = writeEndBlock(z.w, z.crc); z.err != nil {
+		return z.err
+	}
+	z.err = z.w.Flush()
+	return z.err
+}
+
+/* flushBlock(): Starts compressing the buffered input as a block, writing the header
+* first if this is the first block. Unless this is the last block, a rune split across
+* the end of the buffer is kept back for the next block.
+ */
+func (z *Writer) flushBlock(last bool) error {
+	if !z.wroteHeader {
+		//The alphabet is chosen from the first block, unless a dictionary decides it.
+		//The original size is only known if all of the input fit in it.
+		z.header = Header{
+			Size:           -1,
+			Alphabet:       chooseAlphabet(z.Alphabet, z.block),
+			BlockChecksums: z.BlockChecksums,
+			Name:           z.Name,
+			Mode:           z.Mode,
+			ModTime:        z.ModTime,
+			MaxCodeLength:  z.MaxCodeLength,
+			Adaptive:       z.Coding == CodingAdaptive,
+		}
+		if z.Method == MethodBWT {
+			z.header.Alphabet, z.header.BWT = AlphabetBytes, true
+		}
+		z.header.Contexts = z.Method == MethodContext
+		if z.Level != 0 {
+			z.header.Level, z.header.Window = z.Level, z.Window
+			if z.Window == 0 {
+				z.header.Window = DefaultWindow
+			}
+		}
+		if z.Dictionary != nil {
+			z.header.Alphabet = z.Dictionary.Alphabet
+			z.header.DictionaryID = z.Dictionary.ID
+		}
+		if last {
+			z.header.Size = z.size
+		}
+		if err := writeHeader(z.w, &z.header); err != nil {
+			return err
+		}
+		z.wroteHeader = true
+	}
+
+	end := len(z.block)
+	if !last && z.header.Alphabet == AlphabetRunes {
+		end = runeBoundary(z.block)
+	}
+	if end == 0 {
+		return nil
+	}
+
+	//Make room for another block before starting this one, so that no more than
+	//Threads blocks are held in memory at once
+	threads := z.Threads
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	if err := z.writePending(threads - 1); err != nil {
+		return err
+	}
+
+	//The goroutine keeps the buffer, so the rest of the input goes in a new one
+	data := z.block[:end]
+	header, dict := &z.header, z.Dictionary
+	result := make(chan encodedBlock, 1)
+	go func() {
+		encoded, order0Size, err := encodeBlock(data, header, dict)
+		result <- encodedBlock{size: len(data), encoded: encoded, order0Size: order0Size, err: err}
+	}()
+	z.pending = append(z.pending, result)
+
+	if last {
+		z.block = nil
+	} else {
+		z.block = append(make([]byte, 0, cap(z.block)), z.block[end:]...)
+	}
+	return nil
+}
+
+/* writePending(): Waits for the oldest blocks being compressed and writes them out,
+* until at most n are left.
+ */
+func (z *Writer) writePending(n int) error {
+	for len(z.pending) > n {
+		b := <-z.pending[0]
+		z.pending = z.pending[1:]
+		if b.err != nil {
+			return b.err
+		}
+		if err := writeBlock(z.w, b.size, b.encoded); err != nil {
+			return err
+		}
+		z.encodedSize += int64(len(b.encoded))
+		z.order0Size += int64(b.order0Size)
+		if err := z.w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/* ContextGain(): Returns the bytes taken up by the blocks written so far and, with
+* Options.Method MethodContext, the bytes they would have taken with a single code
+* table each, for reporting what context modeling gained.
+ */
+func (z *Writer) ContextGain() (encoded, order0 int64) {
+	return z.encodedSize, z.order0Size
+}